@@ -0,0 +1,88 @@
+package rudp_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/bennychen/rudp"
+)
+
+// steadyPair is two connected RUDP objects exchanging small messages every
+// tick, warmed up until their pools hold everything the exchange needs
+type steadyPair struct {
+	A, B *rudp.RUDP
+	msg  []byte
+	tmp  []byte
+}
+
+func newSteadyPair() *steadyPair {
+	s := &steadyPair{
+		A:   rudp.Create(1, 5, 512),
+		B:   rudp.Create(1, 5, 512),
+		msg: []byte{1, 2, 3, 4, 5, 6, 7, 8},
+		tmp: make([]byte, rudp.MaxPackageSize),
+	}
+	for i := 0; i < 100; i++ {
+		s.step()
+	}
+	return s
+}
+
+func (s *steadyPair) step() {
+	s.A.Send(s.msg, len(s.msg))
+	s.B.Send(s.msg, len(s.msg))
+	p := s.A.Update(nil, 0, 1)
+	for q := p; q != nil; q = q.Next {
+		s.B.Update(q.Buffer, q.Size, 0)
+	}
+	p.Release()
+	p = s.B.Update(nil, 0, 1)
+	for q := p; q != nil; q = q.Next {
+		s.A.Update(q.Buffer, q.Size, 0)
+	}
+	p.Release()
+	for s.A.Recv(s.tmp) > 0 {
+	}
+	for s.B.Recv(s.tmp) > 0 {
+	}
+}
+
+func TestUpdateAllocs(t *testing.T) {
+	fmt.Println("=======================TestUpdateAllocs======================")
+
+	if raceEnabled {
+		t.Skip("pools are not reliable under the race detector")
+	}
+	s := newSteadyPair()
+	if n := testing.AllocsPerRun(100, s.step); n != 0 {
+		t.Errorf("RUDP::Update error, %v allocations per message exchange.", n)
+	}
+
+	idle := rudp.Create(1, 5, 512)
+	idle.Update(nil, 0, 1).Release()
+	heartbeat := []byte{rudp.TypeHeartbeat}
+	if n := testing.AllocsPerRun(100, func() {
+		idle.Update(heartbeat, len(heartbeat), 1).Release()
+	}); n != 0 {
+		t.Errorf("RUDP::Update error, %v allocations per heartbeat.", n)
+	}
+}
+
+func BenchmarkUpdateHeartbeat(b *testing.B) {
+	u := rudp.Create(1, 5, 512)
+	heartbeat := []byte{rudp.TypeHeartbeat}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		u.Update(heartbeat, len(heartbeat), 1).Release()
+	}
+}
+
+func BenchmarkUpdateSmallMessages(b *testing.B) {
+	s := newSteadyPair()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		s.step()
+	}
+}
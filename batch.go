@@ -0,0 +1,156 @@
+package rudp
+
+import (
+	"net"
+	"sync"
+
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+// batchConn is implemented by ipv4.PacketConn and ipv6.PacketConn, they use
+// recvmmsg and sendmmsg on Linux and one datagram per call elsewhere
+type batchConn interface {
+	ReadBatch(ms []ipv4.Message, flags int) (int, error)
+	WriteBatch(ms []ipv4.Message, flags int) (int, error)
+}
+
+// Batch reads and writes the datagrams of a socket shared by many peers in
+// batches of up to size datagrams per syscall. Packages written by Conns
+// created with NewBatchedConn are queued until Flush, so a round of updates
+// for many peers goes out in a single syscall. Sockets other than
+// *net.UDPConn are served one datagram at a time.
+type Batch struct {
+	pc net.PacketConn
+	bc batchConn // nil when pc is no UDP socket
+
+	mu   sync.Mutex
+	out  []ipv4.Message
+	n    int
+	pkgs []*RUDPPackage // queued chains, released after Flush
+
+	in []ipv4.Message
+}
+
+// NewBatch creates a Batch on pc handling up to size datagrams per syscall
+func NewBatch(pc net.PacketConn, size int) *Batch {
+	if size < 1 {
+		size = 1
+	}
+	b := &Batch{pc: pc}
+	if udp, ok := pc.(*net.UDPConn); ok {
+		addr, _ := udp.LocalAddr().(*net.UDPAddr)
+		if addr != nil && addr.IP.To4() == nil && len(addr.IP) == net.IPv6len {
+			b.bc = ipv6.NewPacketConn(udp)
+		} else {
+			b.bc = ipv4.NewPacketConn(udp)
+		}
+	}
+	b.out = make([]ipv4.Message, size)
+	b.in = make([]ipv4.Message, size)
+	for i := 0; i < size; i++ {
+		b.out[i].Buffers = make([][]byte, 1)
+		b.in[i].Buffers = [][]byte{make([]byte, 0x10000)}
+	}
+	return b
+}
+
+// Write queues the package chain p for addr and takes it over, it is
+// released once sent. A full queue is flushed right away.
+func (b *Batch) Write(p *RUDPPackage, addr net.Addr) error {
+	if p == nil {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var err error
+	b.pkgs = append(b.pkgs, p)
+	for ; p != nil; p = p.Next {
+		if b.n == len(b.out) {
+			if e := b.flush(); e != nil {
+				err = e
+			}
+		}
+		m := &b.out[b.n]
+		m.Buffers[0] = p.Buffer[:p.Size]
+		m.Addr = addr
+		b.n++
+	}
+	return err
+}
+
+// Flush sends all queued datagrams, the first write error is returned.
+// Datagrams that failed are dropped, as Conn does with its own writes.
+func (b *Batch) Flush() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	err := b.flush()
+	for i, p := range b.pkgs {
+		p.Release()
+		b.pkgs[i] = nil
+	}
+	b.pkgs = b.pkgs[:0]
+	return err
+}
+
+// flush must be called with b.mu held, it leaves the packages queued
+func (b *Batch) flush() error {
+	var err error
+	if b.bc != nil {
+		for i := 0; i < b.n; {
+			n, e := b.bc.WriteBatch(b.out[i:b.n], 0)
+			if e != nil || n == 0 {
+				err = e
+				break
+			}
+			i += n
+		}
+	} else {
+		for i := 0; i < b.n; i++ {
+			m := &b.out[i]
+			if _, e := b.pc.WriteTo(m.Buffers[0], m.Addr); e != nil && err == nil {
+				err = e
+			}
+		}
+	}
+	for i := 0; i < b.n; i++ {
+		b.out[i].Buffers[0] = nil
+		b.out[i].Addr = nil
+	}
+	b.n = 0
+	return err
+}
+
+// Read waits for datagrams and calls handle for each one received by a
+// single syscall, the datagram is only valid during the call. Read must not
+// be called concurrently.
+func (b *Batch) Read(handle func(datagram []byte, addr net.Addr)) error {
+	if b.bc == nil {
+		n, addr, err := b.pc.ReadFrom(b.in[0].Buffers[0])
+		if err != nil {
+			return err
+		}
+		handle(b.in[0].Buffers[0][:n], addr)
+		return nil
+	}
+	n, err := b.bc.ReadBatch(b.in, 0)
+	if err != nil {
+		return err
+	}
+	for i := 0; i < n; i++ {
+		m := &b.in[i]
+		handle(m.Buffers[0][:m.N], m.Addr)
+	}
+	return nil
+}
+
+// ReadLoop calls Read until it fails and flushes the replies queued by
+// handle after every batch
+func (b *Batch) ReadLoop(handle func(datagram []byte, addr net.Addr)) error {
+	for {
+		if err := b.Read(handle); err != nil {
+			return err
+		}
+		b.Flush()
+	}
+}
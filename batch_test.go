@@ -0,0 +1,144 @@
+package rudp_test
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/bennychen/rudp"
+	"github.com/bennychen/rudp/rudptest"
+)
+
+func listenUDP(t *testing.T) *net.UDPConn {
+	pc, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Skip("no loopback UDP:", err)
+	}
+	return pc
+}
+
+func readDatagram(t *testing.T, pc *net.UDPConn) []byte {
+	pc.SetReadDeadline(time.Now().Add(time.Second))
+	buf := make([]byte, 0x10000)
+	n, _, err := pc.ReadFrom(buf)
+	if err != nil {
+		t.Fatal("Batch error, datagram did not arrive:", err)
+	}
+	return buf[:n]
+}
+
+func TestBatchWrite(t *testing.T) {
+	fmt.Println("=======================TestBatchWrite======================")
+
+	server := listenUDP(t)
+	defer server.Close()
+	b := rudp.NewBatch(server, 2)
+
+	var peers []*net.UDPConn
+	for i := 0; i < 3; i++ {
+		pc := listenUDP(t)
+		defer pc.Close()
+		peers = append(peers, pc)
+
+		U := rudp.Create(1, 5, 128)
+		U.Send([]byte{byte(i)}, 1)
+		U.Send(make([]byte, 200), 200) // a second package
+		if err := b.Write(U.Update(nil, 0, 1), pc.LocalAddr()); err != nil {
+			t.Error("Batch::Write error:", err)
+		}
+	}
+	if err := b.Flush(); err != nil {
+		t.Error("Batch::Flush error:", err)
+	}
+
+	for i, pc := range peers {
+		if d := readDatagram(t, pc); !bytes.Equal(d, []byte{5, 0, 0, byte(i)}) {
+			t.Errorf("Batch error, peer %d got %v.", i, d)
+		}
+		if d := readDatagram(t, pc); len(d) != 2+2+200 {
+			t.Errorf("Batch error, peer %d should get the big package.", i)
+		}
+	}
+}
+
+func TestBatchRead(t *testing.T) {
+	fmt.Println("=======================TestBatchRead======================")
+
+	server := listenUDP(t)
+	defer server.Close()
+	client := listenUDP(t)
+	defer client.Close()
+	b := rudp.NewBatch(server, 8)
+
+	for i := 0; i < 3; i++ {
+		client.WriteTo([]byte{byte(i)}, server.LocalAddr())
+	}
+	server.SetReadDeadline(time.Now().Add(time.Second))
+	var got []byte
+	reads := 0
+	for len(got) < 3 {
+		err := b.Read(func(datagram []byte, addr net.Addr) {
+			if addr.String() != client.LocalAddr().String() {
+				t.Error("Batch::Read error, wrong source address", addr)
+			}
+			got = append(got, datagram...)
+		})
+		if err != nil {
+			t.Fatal("Batch::Read error:", err)
+		}
+		reads++
+	}
+	if !bytes.Equal(got, []byte{0, 1, 2}) {
+		t.Errorf("Batch::Read error, got %v.", got)
+	}
+	if runtime.GOOS == "linux" && reads != 1 {
+		t.Errorf("Batch::Read error, took %d reads for 3 queued datagrams.", reads)
+	}
+}
+
+func TestBatchedConn(t *testing.T) {
+	fmt.Println("=======================TestBatchedConn======================")
+
+	clock := rudptest.NewFakeClock(time.Unix(1000, 0))
+	server := listenUDP(t)
+	defer server.Close()
+	client := listenUDP(t)
+	defer client.Close()
+	b := rudp.NewBatch(server, 8)
+
+	C := rudp.NewBatchedConn(b, client.LocalAddr(),
+		rudp.CreateWithDuration(10*time.Millisecond, time.Second, 128, rudp.Options{}), clock)
+	defer C.Close()
+
+	C.Send([]byte{7})
+	clock.Advance(10 * time.Millisecond)
+	if d := readDatagram(t, client); !bytes.Equal(d, []byte{5, 0, 0, 7}) {
+		t.Error("Conn error, send timer should flush the message.")
+	}
+	r := []byte{rudp.TypeRequest, 0, 0}
+	C.Input(r)
+	clock.Advance(10 * time.Millisecond) // the timer flushes the resend
+	if d := readDatagram(t, client); !bytes.Equal(d, []byte{5, 0, 0, 7}) {
+		t.Error("Conn error, requested message should be resent.")
+	}
+}
+
+func TestBatchFallback(t *testing.T) {
+	fmt.Println("=======================TestBatchFallback======================")
+
+	pc := &queueConn{addr: &net.UDPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 1}}
+	b := rudp.NewBatch(pc, 4)
+	U := rudp.Create(1, 5, 128)
+	U.Send([]byte{1}, 1)
+	b.Write(U.Update(nil, 0, 1), pc.addr)
+	if len(pc.written) != 0 {
+		t.Error("Batch::Write error, should wait for Flush.")
+	}
+	b.Flush()
+	if len(pc.written) != 1 || !bytes.Equal(pc.written[0], []byte{5, 0, 0, 1}) {
+		t.Error("Batch::Flush error, should write through WriteTo.")
+	}
+}
@@ -0,0 +1,170 @@
+package rudp
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// Peers exchange their capabilities before creating RUDP objects, so they
+// agree on the wire format instead of silently misreading each other. Like
+// the handshake the message is plain bytes the caller carries over UDP:
+//
+//	| type (1 byte) | version (1 byte) | max message size (2 bytes) |
+//	| features (1 byte) | required (1 byte) |
+//	| fec group size (1 byte) | fec mode (1 byte) | fec parity shards (1 byte) |
+
+// ProtocolVersion is the wire protocol version spoken by this package
+const ProtocolVersion = 1
+
+// optional features a peer may support or require
+const (
+	FeatureID32 = 1 << iota
+	FeatureChecksum
+	FeatureCompression
+	FeatureFEC
+)
+
+const (
+	handshakeCapabilities = 4
+	capabilitiesSize      = 9
+)
+
+var (
+	ErrVersionMismatch = errors.New("rudp: peer speaks another protocol version")
+	ErrIncompatible    = errors.New("rudp: peer is incompatible")
+)
+
+// Capabilities describe what a peer is able and willing to do
+type Capabilities struct {
+	Version        int // ProtocolVersion of the peer
+	MaxMessageSize int // largest message the peer sends or accepts
+	Features       int // Feature bits the peer is willing to use
+	Required       int // Feature bits the peer refuses to work without
+
+	// preferred FEC settings when FeatureFEC is supported
+	FECGroupSize    int
+	FECMode         int
+	FECParityShards int
+}
+
+// LocalCapabilities returns capabilities offering everything turned on in
+// opts, set Required on the result for features that must not be dropped
+func LocalCapabilities(opts Options) Capabilities {
+	c := Capabilities{
+		Version:        ProtocolVersion,
+		MaxMessageSize: MaxPackageSize,
+	}
+	// advertised as the codec would use them
+	c.FECGroupSize, c.FECMode, c.FECParityShards = fecSettings(opts)
+	if opts.MaxMessageSize > 0 && opts.MaxMessageSize < MaxPackageSize {
+		c.MaxMessageSize = opts.MaxMessageSize
+	}
+	if opts.IDWidth == IDWidth32 {
+		c.Features |= FeatureID32
+	}
+	if opts.Checksum {
+		c.Features |= FeatureChecksum
+	}
+	if opts.Compression {
+		c.Features |= FeatureCompression
+	}
+	if c.FECGroupSize > 0 {
+		c.Features |= FeatureFEC
+	}
+	return c
+}
+
+// Marshal returns the capabilities message to send to the peer
+func (c Capabilities) Marshal() []byte {
+	b := make([]byte, capabilitiesSize)
+	b[0] = handshakeCapabilities
+	b[1] = byte(c.Version)
+	binary.BigEndian.PutUint16(b[2:], uint16(c.MaxMessageSize))
+	b[4] = byte(c.Features)
+	b[5] = byte(c.Required)
+	b[6] = byte(c.FECGroupSize)
+	b[7] = byte(c.FECMode)
+	b[8] = byte(c.FECParityShards)
+	return b
+}
+
+// ParseCapabilities reads a capabilities message from the peer
+func ParseCapabilities(b []byte) (Capabilities, error) {
+	if len(b) != capabilitiesSize || b[0] != handshakeCapabilities {
+		return Capabilities{}, ErrHandshake
+	}
+	c := Capabilities{
+		Version:         int(b[1]),
+		MaxMessageSize:  int(binary.BigEndian.Uint16(b[2:])),
+		Features:        int(b[4]),
+		Required:        int(b[5]),
+		FECGroupSize:    int(b[6]),
+		FECMode:         int(b[7]),
+		FECParityShards: int(b[8]),
+	}
+	if !c.validFEC() {
+		return Capabilities{}, ErrHandshake
+	}
+	return c, nil
+}
+
+// validFEC reports whether the FEC settings offered are used by the codec
+// as they are, so both peers agree on the wire format
+func (c Capabilities) validFEC() bool {
+	if c.Features&FeatureFEC == 0 {
+		return true
+	}
+	d, m, p := fecSettings(Options{
+		FECGroupSize:    c.FECGroupSize,
+		FECMode:         c.FECMode,
+		FECParityShards: c.FECParityShards,
+	})
+	return d > 0 && d == c.FECGroupSize && m == c.FECMode && p == c.FECParityShards
+}
+
+// Negotiate returns the options both peers end up with, features are only
+// turned on when both peers offer them. Both peers get the same result
+// from their own view of the exchange.
+func Negotiate(local Capabilities, remote Capabilities) (Options, error) {
+	if local.Version != remote.Version {
+		return Options{}, fmt.Errorf("%w: peer speaks version %d, we speak version %d",
+			ErrVersionMismatch, remote.Version, local.Version)
+	}
+	if !local.validFEC() || !remote.validFEC() {
+		return Options{}, fmt.Errorf("%w: FEC settings out of range", ErrIncompatible)
+	}
+	features := local.Features & remote.Features
+	if local.FECMode != remote.FECMode {
+		features &^= FeatureFEC
+	}
+	if missing := (local.Required | remote.Required) &^ features; missing != 0 {
+		return Options{}, fmt.Errorf("%w: features %#x are required but not agreed on",
+			ErrIncompatible, missing)
+	}
+
+	opts := Options{}
+	opts.MaxMessageSize = minInt(local.MaxMessageSize, remote.MaxMessageSize)
+	if opts.MaxMessageSize <= 0 {
+		return Options{}, fmt.Errorf("%w: max message size %d",
+			ErrIncompatible, opts.MaxMessageSize)
+	}
+	if features&FeatureID32 != 0 {
+		opts.IDWidth = IDWidth32
+	}
+	opts.Checksum = features&FeatureChecksum != 0
+	opts.Compression = features&FeatureCompression != 0
+	if features&FeatureFEC != 0 {
+		opts.FECGroupSize = minInt(local.FECGroupSize, remote.FECGroupSize)
+		opts.FECMode = local.FECMode
+		opts.FECParityShards = minInt(local.FECParityShards, remote.FECParityShards)
+	}
+	return opts, nil
+}
+
+func minInt(a int, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
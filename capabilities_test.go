@@ -0,0 +1,94 @@
+package rudp_test
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/bennychen/rudp"
+)
+
+func TestNegotiate(t *testing.T) {
+	fmt.Println("=======================TestNegotiate======================")
+
+	local := rudp.LocalCapabilities(rudp.Options{
+		IDWidth:      rudp.IDWidth32,
+		Checksum:     true,
+		Compression:  true,
+		FECGroupSize: 8,
+	})
+	// a peer limited to 1200 bytes messages
+	remote := rudp.LocalCapabilities(rudp.Options{
+		MaxMessageSize: 1200,
+		Checksum:       true,
+		FECGroupSize:   4,
+	})
+	remote, err := rudp.ParseCapabilities(remote.Marshal())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opts, err := rudp.Negotiate(local, remote)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opts.MaxMessageSize != 1200 || opts.IDWidth == rudp.IDWidth32 ||
+		!opts.Checksum || opts.Compression || opts.FECGroupSize != 4 {
+		t.Errorf("Negotiate error, unexpected options %+v.", opts)
+	}
+	if other, _ := rudp.Negotiate(remote, local); other.MaxMessageSize != opts.MaxMessageSize ||
+		other.Checksum != opts.Checksum || other.FECGroupSize != opts.FECGroupSize {
+		t.Error("Negotiate error, both peers should agree on the same options.")
+	}
+
+	U := rudp.CreateWithOptions(1, 5, 128, opts)
+	U.Send(make([]byte, 1201), 1201)
+	if p := U.Update(nil, 0, 1); p == nil || p.Next != nil || p.Size > 128 {
+		t.Error("RUDP::Send error, message above the negotiated size should be refused.")
+	}
+
+	// a peer ignoring the negotiated size corrupts the connection
+	peer := rudp.Create(1, 5, 2048)
+	peer.Send(make([]byte, 1201), 1201)
+	p := peer.Update(nil, 0, 1)
+	U = rudp.CreateWithOptions(1, 5, 128, rudp.Options{MaxMessageSize: opts.MaxMessageSize})
+	U.Update(p.Buffer, p.Size, 0)
+	if U.Recv(make([]byte, rudp.MaxPackageSize)) != -1 {
+		t.Error("RUDP::Recv error, message above the negotiated size should corrupt.")
+	}
+
+	local.Required = rudp.FeatureCompression
+	if _, err := rudp.Negotiate(local, remote); !errors.Is(err, rudp.ErrIncompatible) {
+		t.Error("Negotiate error, missing required feature should be refused.")
+	}
+
+	remote.Version = rudp.ProtocolVersion + 1
+	if _, err := rudp.Negotiate(local, remote); !errors.Is(err, rudp.ErrVersionMismatch) {
+		t.Error("Negotiate error, other protocol version should be refused.")
+	}
+
+	if _, err := rudp.ParseCapabilities([]byte{4, 1}); err != rudp.ErrHandshake {
+		t.Error("ParseCapabilities error, truncated message should be refused.")
+	}
+
+	// FEC settings are advertised as the codec uses them
+	c := rudp.LocalCapabilities(rudp.Options{FECGroupSize: 256})
+	if c.FECGroupSize != 254 || c.Features&rudp.FeatureFEC == 0 {
+		t.Errorf("LocalCapabilities error, group size should be clamped not %d.", c.FECGroupSize)
+	}
+	if back, err := rudp.ParseCapabilities(c.Marshal()); err != nil || back != c {
+		t.Error("ParseCapabilities error, clamped settings should survive Marshal.")
+	}
+	c = rudp.LocalCapabilities(rudp.Options{FECGroupSize: 200,
+		FECMode: rudp.FECReedSolomon, FECParityShards: 100})
+	if c.FECGroupSize+c.FECParityShards != 255 {
+		t.Error("LocalCapabilities error, parity shards should be clamped.")
+	}
+	c.FECParityShards = 100
+	if _, err := rudp.ParseCapabilities(c.Marshal()); err != rudp.ErrHandshake {
+		t.Error("ParseCapabilities error, settings the codec clamps should be refused.")
+	}
+	if _, err := rudp.Negotiate(c, c); !errors.Is(err, rudp.ErrIncompatible) {
+		t.Error("Negotiate error, settings the codec clamps should be refused.")
+	}
+}
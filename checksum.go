@@ -0,0 +1,39 @@
+package rudp
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+)
+
+// With checksums enabled every package ends with
+// | CRC32C of the package (4 bytes) |
+// a package failing the check is dropped as if it was lost, rather than
+// being parsed into garbage messages or a corrupt connection.
+
+const checksumSize = 4
+
+var checksumTable = crc32.MakeTable(crc32.Castagnoli)
+
+// appendChecksums replaces every package with a copy ending with its CRC32C
+func appendChecksums(p *RUDPPackage) *RUDPPackage {
+	tmp := &tmpBuffer{}
+	for ; p != nil; p = p.Next {
+		q := tmp.createEmptyPackage(p.Size + checksumSize)
+		copy(q.Buffer, p.Buffer[:p.Size])
+		binary.BigEndian.PutUint32(q.Buffer[p.Size:],
+			crc32.Checksum(p.Buffer[:p.Size], checksumTable))
+	}
+	return tmp.head
+}
+
+// verifyChecksum returns the package without its trailer, or false if the
+// trailer does not match
+func (u *RUDP) verifyChecksum(buffer []byte) ([]byte, bool) {
+	sz := len(buffer) - checksumSize
+	if sz < 0 || crc32.Checksum(buffer[:sz], checksumTable) !=
+		binary.BigEndian.Uint32(buffer[sz:]) {
+		u.stats.ChecksumFailures++
+		return nil, false
+	}
+	return buffer[:sz], true
+}
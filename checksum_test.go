@@ -0,0 +1,43 @@
+package rudp_test
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/bennychen/rudp"
+)
+
+func TestChecksum(t *testing.T) {
+	fmt.Println("=======================TestChecksum======================")
+
+	idx = 0
+	opts := rudp.Options{Checksum: true}
+	A := rudp.CreateWithOptions(1, 5, 128, opts)
+	B := rudp.CreateWithOptions(1, 5, 128, opts)
+
+	p := A.Update(nil, 0, 1)
+	if p == nil || p.Next != nil ||
+		bytes.Compare(p.Buffer, []byte{rudp.TypeHeartbeat, 0x52, 0x7d, 0x53, 0x51}) != 0 {
+		t.Error("RUDP::Update error, should send a heartbeat with its CRC32C.")
+	}
+	dump(p)
+
+	A.Send([]byte{1, 2, 3, 4}, 4)
+	p = A.Update(nil, 0, 1)
+	dump(p)
+
+	// a flipped payload bit is dropped instead of delivered
+	flipped := append([]byte(nil), p.Buffer...)
+	flipped[5] ^= 0x10
+	B.Update(flipped, len(flipped), 0)
+	B.Update(p.Buffer[:2], 2, 0)
+	if dumpRecv(B) != "" || B.Stats().ChecksumFailures != 2 {
+		t.Error("RUDP::Update error, packages failing the checksum should be dropped.")
+	}
+
+	B.Update(p.Buffer, p.Size, 0)
+	if dumpRecv(B) != "RECV 1 2 3 4\n" {
+		t.Error("RUDP::Recv error, should receive the checked message.")
+	}
+}
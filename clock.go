@@ -0,0 +1,30 @@
+package rudp
+
+import "time"
+
+// Clock is the source of time for the socket level wrappers, tests replace
+// it with a manual clock such as rudptest.FakeClock to step time by hand
+type Clock interface {
+	Now() time.Time
+	// AfterFunc calls f once d has passed
+	AfterFunc(d time.Duration, f func()) Timer
+}
+
+// Timer is a pending AfterFunc call
+type Timer interface {
+	// Stop prevents the call, false is returned if it already happened
+	Stop() bool
+}
+
+// SystemClock is the Clock of the time package
+var SystemClock Clock = systemClock{}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (systemClock) AfterFunc(d time.Duration, f func()) Timer {
+	return time.AfterFunc(d, f)
+}
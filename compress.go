@@ -0,0 +1,81 @@
+package rudp
+
+import (
+	"bytes"
+	"compress/flate"
+	"io"
+)
+
+// With compression enabled every package starts with a flag byte. Packages
+// that DEFLATE makes smaller carry compressFlagDeflate followed by the
+// compressed bytes, all the others carry compressFlagRaw followed by the
+// original package.
+
+const (
+	compressFlagRaw     = 0
+	compressFlagDeflate = 1
+	compressHeaderSize  = 1
+	compressMaxSize     = 0x10000 // larger than any package genOutPackage produces
+)
+
+type compressor struct {
+	writer *flate.Writer
+	reader io.ReadCloser
+	in     bytes.Reader
+	out    bytes.Buffer
+	plain  bytes.Buffer
+}
+
+func newCompressor() *compressor {
+	w, _ := flate.NewWriter(nil, flate.BestSpeed)
+	return &compressor{writer: w}
+}
+
+// compress replaces every package with its flagged form, compressed only
+// when that saves space
+func (c *compressor) compress(p *RUDPPackage) *RUDPPackage {
+	tmp := &tmpBuffer{}
+	for ; p != nil; p = p.Next {
+		c.out.Reset()
+		c.writer.Reset(&c.out)
+		c.writer.Write(p.Buffer[:p.Size])
+		c.writer.Close()
+
+		if c.out.Len() < p.Size {
+			q := tmp.createEmptyPackage(compressHeaderSize + c.out.Len())
+			q.Buffer[0] = compressFlagDeflate
+			copy(q.Buffer[compressHeaderSize:], c.out.Bytes())
+		} else {
+			q := tmp.createEmptyPackage(compressHeaderSize + p.Size)
+			q.Buffer[0] = compressFlagRaw
+			copy(q.Buffer[compressHeaderSize:], p.Buffer[:p.Size])
+		}
+	}
+	return tmp.head
+}
+
+// decompress returns the original package, the returned buffer is only
+// valid until the next call
+func (c *compressor) decompress(buffer []byte) ([]byte, bool) {
+	if len(buffer) < compressHeaderSize {
+		return nil, false
+	}
+	switch buffer[0] {
+	case compressFlagRaw:
+		return buffer[compressHeaderSize:], true
+	case compressFlagDeflate:
+		c.in.Reset(buffer[compressHeaderSize:])
+		if c.reader == nil {
+			c.reader = flate.NewReader(&c.in)
+		} else {
+			c.reader.(flate.Resetter).Reset(&c.in, nil)
+		}
+		c.plain.Reset()
+		n, err := c.plain.ReadFrom(io.LimitReader(c.reader, compressMaxSize+1))
+		if err != nil || n > compressMaxSize {
+			return nil, false
+		}
+		return c.plain.Bytes(), true
+	}
+	return nil, false
+}
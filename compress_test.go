@@ -0,0 +1,74 @@
+package rudp_test
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/bennychen/rudp"
+)
+
+func TestCompression(t *testing.T) {
+	fmt.Println("=======================TestCompression======================")
+
+	idx = 0
+	opts := rudp.Options{Compression: true}
+	A := rudp.CreateWithOptions(1, 5, 512, opts)
+	B := rudp.CreateWithOptions(1, 5, 512, opts)
+
+	p := A.Update(nil, 0, 1)
+	if p == nil || p.Next != nil ||
+		bytes.Compare(p.Buffer, []byte{0, rudp.TypeHeartbeat}) != 0 {
+		t.Error("RUDP::Update error, heartbeat should be sent raw.")
+	}
+	dump(p)
+
+	msg := bytes.Repeat([]byte("{\"x\":1,\"y\":2},"), 20)
+	A.Send(msg, len(msg))
+	p = A.Update(nil, 0, 1)
+	if p == nil || p.Next != nil || p.Buffer[0] != 1 || p.Size >= len(msg) {
+		t.Error("RUDP::Update error, should send 1 compressed package.")
+	}
+	dump(p)
+
+	B.Update(p.Buffer, p.Size, 0)
+	tmp := make([]byte, rudp.MaxPackageSize)
+	n := B.Recv(tmp)
+	if n != len(msg) || bytes.Compare(tmp[:n], msg) != 0 {
+		t.Error("RUDP::Recv error, should receive the decompressed message.")
+	}
+
+	r := []byte{1, 0xff, 0xff}
+	B.Update(r, len(r), 0)
+	if dumpRecv(B) != "CORRUPT\n" {
+		t.Error("Should get a corrupt signal for a broken compressed package.")
+	}
+}
+
+func TestCompressionWithFEC(t *testing.T) {
+	fmt.Println("=======================TestCompressionWithFEC======================")
+
+	idx = 0
+	opts := rudp.Options{Compression: true, FECGroupSize: 2}
+	A := rudp.CreateWithOptions(1, 5, 512, opts)
+	B := rudp.CreateWithOptions(1, 5, 512, opts)
+
+	msg := bytes.Repeat([]byte{1, 2, 3, 4}, 50)
+	A.Send(msg, len(msg))
+	dump(A.Update(nil, 0, 1)) // lost
+	A.Send([]byte{5}, 1)
+	p := A.Update(nil, 0, 1)
+	dump(p)
+	for ; p != nil; p = p.Next {
+		B.Update(p.Buffer, p.Size, 0)
+	}
+
+	tmp := make([]byte, rudp.MaxPackageSize)
+	n := B.Recv(tmp)
+	if n != len(msg) || bytes.Compare(tmp[:n], msg) != 0 {
+		t.Error("RUDP::Recv error, compressed package should be rebuilt from parity.")
+	}
+	if dumpRecv(B) != "RECV 5\n" {
+		t.Error("RUDP::Recv error, should receive the second message.")
+	}
+}
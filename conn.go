@@ -0,0 +1,181 @@
+package rudp
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+// Conn drives a RUDP object for one peer over a net.PacketConn. A timer of
+// its Clock wakes it at the NextDeadline of the RUDP object, received
+// datagrams come in through Input, or through ReadLoop when the socket is
+// dedicated to the peer. Many Conns can share a Scheduler as their Clock.
+type Conn struct {
+	mu      sync.Mutex
+	rudp    *RUDP
+	pc      net.PacketConn
+	batch   *Batch // nil when packages are written right away
+	remote  net.Addr
+	clock   Clock
+	timer   Timer
+	closed  bool
+	onClose func() // set by Listener to forget the peer
+	hello   []byte // server hello of a Listener handshake
+}
+
+// NewConn starts driving u for remote over pc, a nil clock means SystemClock
+func NewConn(pc net.PacketConn, remote net.Addr, u *RUDP, clock Clock) *Conn {
+	return newConn(pc, nil, remote, u, clock)
+}
+
+// NewBatchedConn is NewConn writing through b. Packages are queued in b
+// until its owner flushes it: Batch.ReadLoop does so after every batch it
+// reads and a Scheduler clock after every tick it runs, so all the Conns
+// due on one tick share the syscalls. With another Clock the send timer
+// flushes b itself.
+func NewBatchedConn(b *Batch, remote net.Addr, u *RUDP, clock Clock) *Conn {
+	return newConn(b.pc, b, remote, u, clock)
+}
+
+func newConn(pc net.PacketConn, b *Batch, remote net.Addr, u *RUDP, clock Clock) *Conn {
+	if clock == nil {
+		clock = SystemClock
+	}
+	c := &Conn{rudp: u, pc: pc, batch: b, remote: remote, clock: clock}
+	c.mu.Lock()
+	c.update(nil)
+	c.timer = clock.AfterFunc(c.nextDelay(), c.onTimer)
+	c.mu.Unlock()
+	return c
+}
+
+// RemoteAddr returns the address of the peer
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.remote
+}
+
+// Send queues a message, it goes out on the next send tick
+func (c *Conn) Send(buffer []byte) {
+	c.mu.Lock()
+	c.rudp.Send(buffer, len(buffer))
+	c.mu.Unlock()
+}
+
+// SendV queues the parts joined together as one message
+func (c *Conn) SendV(parts [][]byte) {
+	c.mu.Lock()
+	c.rudp.SendV(parts)
+	c.mu.Unlock()
+}
+
+// Recv works like RUDP.Recv
+func (c *Conn) Recv(buffer []byte) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.rudp.Recv(buffer)
+}
+
+// RecvMessage works like RUDP.RecvMessage
+func (c *Conn) RecvMessage() (*Message, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.rudp.RecvMessage()
+}
+
+// NextSize works like RUDP.NextSize
+func (c *Conn) NextSize() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.rudp.NextSize()
+}
+
+// Input handles a datagram received from the peer
+func (c *Conn) Input(datagram []byte) {
+	c.mu.Lock()
+	if !c.closed {
+		c.update(datagram)
+	}
+	c.mu.Unlock()
+}
+
+// ReadLoop reads datagrams from the socket until it fails, datagrams from
+// other addresses than the peer are ignored
+func (c *Conn) ReadLoop() error {
+	buffer := make([]byte, 0x10000)
+	for {
+		n, addr, err := c.pc.ReadFrom(buffer)
+		if err != nil {
+			return err
+		}
+		if addr.String() == c.remote.String() {
+			c.Input(buffer[:n])
+		}
+	}
+}
+
+// Close stops the send timer, the socket is left to its owner
+func (c *Conn) Close() error {
+	c.mu.Lock()
+	closed := c.closed
+	c.closed = true
+	c.timer.Stop()
+	c.mu.Unlock()
+	if !closed && c.onClose != nil {
+		c.onClose()
+	}
+	return nil
+}
+
+func (c *Conn) onTimer() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return
+	}
+	c.update(nil)
+	c.flush()
+	c.timer = c.clock.AfterFunc(c.nextDelay(), c.onTimer)
+}
+
+// heard reports whether an authentic package of the peer arrived, until
+// then a Listener answers repeated hellos with the same server hello
+func (c *Conn) heard() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.rudp.sealer != nil && c.rudp.sealer.pinned
+}
+
+// nextDelay must be called with c.mu held, it is at least one tick
+func (c *Conn) nextDelay() time.Duration {
+	d := c.rudp.NextDeadlineAt().Sub(c.clock.Now())
+	if d < c.rudp.tick {
+		d = c.rudp.tick
+	}
+	return d
+}
+
+// update must be called with c.mu held
+func (c *Conn) update(datagram []byte) {
+	p := c.rudp.UpdateAt(datagram, len(datagram), c.clock.Now())
+	if c.batch != nil {
+		c.batch.Write(p, c.remote)
+		return
+	}
+	for q := p; q != nil; q = q.Next {
+		// UDP write errors are transient, lost packages are requested again
+		c.pc.WriteTo(q.Buffer[:q.Size], c.remote)
+	}
+	p.Release()
+}
+
+// flush leaves the batch to the Scheduler running the current tick if
+// there is one
+func (c *Conn) flush() {
+	if c.batch == nil {
+		return
+	}
+	if s, ok := c.clock.(*Scheduler); ok && s.flushAfterTick(c.batch) {
+		return
+	}
+	c.batch.Flush()
+}
@@ -0,0 +1,98 @@
+package rudp_test
+
+import (
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/bennychen/rudp"
+	"github.com/bennychen/rudp/rudptest"
+)
+
+// queueConn is a net.PacketConn keeping written datagrams for the test to
+// deliver by hand
+type queueConn struct {
+	net.PacketConn
+	addr    net.Addr
+	written [][]byte
+}
+
+func (c *queueConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	c.written = append(c.written, append([]byte(nil), b...))
+	return len(b), nil
+}
+
+func (c *queueConn) LocalAddr() net.Addr {
+	return c.addr
+}
+
+func (c *queueConn) deliver(to *rudp.Conn) int {
+	n := len(c.written)
+	for _, b := range c.written {
+		to.Input(b)
+	}
+	c.written = nil
+	return n
+}
+
+func TestConnWithFakeClock(t *testing.T) {
+	fmt.Println("=======================TestConnWithFakeClock======================")
+
+	clock := rudptest.NewFakeClock(time.Unix(1000, 0))
+	pcA := &queueConn{addr: &net.UDPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 1}}
+	pcB := &queueConn{addr: &net.UDPAddr{IP: net.IPv4(10, 0, 0, 2), Port: 2}}
+	A := rudp.NewConn(pcA, pcB.addr,
+		rudp.CreateWithDuration(10*time.Millisecond, time.Second, 128, rudp.Options{}), clock)
+	B := rudp.NewConn(pcB, pcA.addr,
+		rudp.CreateWithDuration(10*time.Millisecond, time.Second, 128, rudp.Options{}), clock)
+	defer A.Close()
+	defer B.Close()
+
+	A.Send([]byte{1, 2, 3})
+	clock.Advance(9 * time.Millisecond)
+	if len(pcA.written) != 0 {
+		t.Error("Conn error, should not send before send delay.")
+	}
+	clock.Advance(time.Millisecond)
+	if pcA.deliver(B) != 1 {
+		t.Error("Conn error, should send 1 package after send delay.")
+	}
+
+	tmp := make([]byte, rudp.MaxPackageSize)
+	if n := B.Recv(tmp); n != 3 || tmp[0] != 1 || tmp[2] != 3 {
+		t.Error("Conn error, should receive the message.")
+	}
+
+	// a lost package is requested again on the next send ticks
+	A.Send([]byte{4})
+	A.Send([]byte{5})
+	clock.Advance(10 * time.Millisecond)
+	pcA.written = nil
+	A.Send([]byte{6})
+	clock.Advance(10 * time.Millisecond)
+	pcA.deliver(B)
+	pcB.written = nil
+	clock.Advance(10 * time.Millisecond)
+	pcB.deliver(A)
+	clock.Advance(10 * time.Millisecond)
+	pcA.deliver(B)
+	if dumpConn(B) != "RECV 4\nRECV 5\nRECV 6\n" {
+		t.Error("Conn error, lost messages should be resent.")
+	}
+
+	A.Close()
+	clock.Advance(time.Second)
+	if len(pcA.written) != 0 {
+		t.Error("Conn error, closed conn should not send.")
+	}
+}
+
+func dumpConn(c *rudp.Conn) string {
+	tmp := make([]byte, rudp.MaxPackageSize)
+	str := ""
+	for n := c.Recv(tmp); n > 0; n = c.Recv(tmp) {
+		str += fmt.Sprintf("RECV %v\n", tmp[0])
+	}
+	return str
+}
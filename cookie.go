@@ -0,0 +1,92 @@
+package rudp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	cookieSize       = 16
+	cookieSecretSize = 32
+)
+
+// CookieJar issues and checks stateless cookies bound to a source address,
+// like the HelloVerify round trip of DTLS. A server only creates per peer
+// state for hellos carrying a valid cookie, which a spoofed source address
+// never receives. The HMAC secret rotates every interval, cookies stay
+// valid for one more interval after a rotation.
+type CookieJar struct {
+	mu       sync.Mutex
+	clock    Clock
+	interval time.Duration
+	rotated  time.Time
+	current  []byte
+	previous []byte
+}
+
+// NewCookieJar creates a cookie jar rotating its secret every interval of
+// clock, a nil clock means SystemClock
+func NewCookieJar(interval time.Duration, clock Clock) *CookieJar {
+	if clock == nil {
+		clock = SystemClock
+	}
+	j := &CookieJar{clock: clock, interval: interval}
+	j.current = newCookieSecret()
+	j.previous = newCookieSecret()
+	j.rotated = clock.Now()
+	return j
+}
+
+func newCookieSecret() []byte {
+	secret := make([]byte, cookieSecretSize)
+	if _, err := rand.Read(secret); err != nil {
+		panic("rudp: " + err.Error())
+	}
+	return secret
+}
+
+// Cookie returns the current cookie for addr
+func (j *CookieJar) Cookie(addr net.Addr) []byte {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.rotate()
+	return cookieMAC(j.current, addr)
+}
+
+// Verify checks a cookie for addr against the current and previous secret
+func (j *CookieJar) Verify(addr net.Addr, cookie []byte) bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.rotate()
+	return hmac.Equal(cookie, cookieMAC(j.current, addr)) ||
+		hmac.Equal(cookie, cookieMAC(j.previous, addr))
+}
+
+func (j *CookieJar) rotate() {
+	now := j.clock.Now()
+	if now.Sub(j.rotated) < j.interval {
+		return
+	}
+	if now.Sub(j.rotated) >= 2*j.interval {
+		// no cookie of the current secret is young enough to keep
+		j.previous = newCookieSecret()
+	} else {
+		j.previous = j.current
+	}
+	j.current = newCookieSecret()
+	j.rotated = now
+}
+
+func cookieMAC(secret []byte, addr net.Addr) []byte {
+	mac := hmac.New(sha256.New, secret)
+	if addr != nil {
+		mac.Write([]byte(addr.Network()))
+		mac.Write([]byte{0})
+		mac.Write([]byte(addr.String()))
+	}
+	return mac.Sum(nil)[:cookieSize]
+}
@@ -0,0 +1,67 @@
+package rudp_test
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/bennychen/rudp"
+	"github.com/bennychen/rudp/rudptest"
+)
+
+func TestCookieJar(t *testing.T) {
+	fmt.Println("=======================TestCookieJar======================")
+
+	a := &net.UDPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 4000}
+	b := &net.UDPAddr{IP: net.IPv4(10, 0, 0, 2), Port: 4000}
+	clock := rudptest.NewFakeClock(time.Unix(1000, 0))
+	jar := rudp.NewCookieJar(20*time.Millisecond, clock)
+
+	cookie := jar.Cookie(a)
+	if !jar.Verify(a, cookie) || jar.Verify(b, cookie) || jar.Verify(a, nil) {
+		t.Error("CookieJar error, cookie should only be valid for its address.")
+	}
+
+	clock.Advance(25 * time.Millisecond)
+	if !jar.Verify(a, cookie) || bytes.Compare(jar.Cookie(a), cookie) == 0 {
+		t.Error("CookieJar error, cookie should survive one rotation.")
+	}
+	clock.Advance(45 * time.Millisecond)
+	if jar.Verify(a, cookie) {
+		t.Error("CookieJar error, cookie should expire after two rotations.")
+	}
+}
+
+func TestHandshakeCookie(t *testing.T) {
+	fmt.Println("=======================TestHandshakeCookie======================")
+
+	addr := &net.UDPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 4000}
+	spoofed := &net.UDPAddr{IP: net.IPv4(10, 0, 0, 2), Port: 4000}
+	server := rudp.NewServerHandshake(nil)
+	server.RequireCookie(rudp.NewCookieJar(time.Minute, nil))
+
+	client, _ := rudp.NewClientHandshake(nil)
+	reply, keys, err := server.Accept(addr, client.Hello())
+	if err != nil || keys != nil || reply == nil {
+		t.Fatal("Handshake error, hello without cookie should be challenged.")
+	}
+	if _, err := client.Finish(reply); err != rudp.ErrHelloVerify {
+		t.Fatal("Handshake error, client should be asked to send hello again.")
+	}
+
+	// the cookie is useless from another address
+	if _, keys, _ := server.Accept(spoofed, client.Hello()); keys != nil {
+		t.Error("Handshake error, cookie should not be accepted from another address.")
+	}
+
+	reply, keys, err = server.Accept(addr, client.Hello())
+	if err != nil || keys == nil {
+		t.Fatal("Handshake error, hello with cookie should be accepted.")
+	}
+	clientKeys, err := client.Finish(reply)
+	if err != nil || bytes.Compare(clientKeys.Send, keys.Recv) != 0 {
+		t.Error("Handshake error, both sides should derive the same keys.")
+	}
+}
@@ -0,0 +1,201 @@
+package rudp
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+)
+
+// With encryption enabled every package is sealed with AES-GCM as
+// | salt (16 bytes) | packet number (8 bytes) | ciphertext | tag (16 bytes) |
+// the salt is picked at random by each RUDP object and sent in the clear,
+// the AES key is derived from the configured key and the salt. Every RUDP
+// object seals under a key of its own, so any number of them may share a
+// key while the nonce, the packet number counting from 0, is never used
+// twice under one AES key.
+// Packages failing authentication are dropped before any of their content
+// is looked at. The first authentic package pins the salt of the peer,
+// packages carrying another salt are dropped from then on without deriving
+// any key, so the replay state of the peer is never lost: its packages that
+// were already received, or that fall too far behind the newest one, are
+// dropped as replays. So are our own packages reflected back under a
+// shared key. A package recorded in an earlier session under the same key
+// could pin its stale salt and lock the peer out, handshake keys and a
+// SessionID change the keys of every session so such packages fail
+// authentication. Peers exchanging their salts at setup through Salt and
+// PeerSalt never derive a key for an unknown salt.
+
+const (
+	sealSaltSize     = 16
+	sealNumberSize   = 8
+	sealNonceSize    = 12
+	sealTagSize      = 16
+	sealHeaderSize   = sealSaltSize + sealNumberSize
+	sealOverhead     = sealHeaderSize + sealTagSize
+	sealInfo         = "rudp seal"
+	replayWindowSize = 64 // packet numbers tracked below the newest one
+)
+
+type sealer struct {
+	sendAEAD cipher.AEAD
+	shared   bool // the same key both ways
+	salt     [sealSaltSize]byte
+	counter  uint64
+	nonce    [sealNonceSize]byte
+	plain    []byte
+
+	recvKey  []byte
+	session  []byte
+	recvAEAD cipher.AEAD // derived from peerSalt once it is pinned
+	peerSalt [sealSaltSize]byte
+	pinned   bool
+	replay   replayWindow
+}
+
+// replayWindow remembers the newest packet number received and which of
+// the replayWindowSize packet numbers below it were received
+type replayWindow struct {
+	newest  uint64
+	seen    uint64 // bit i is set when newest - i was received
+	started bool
+}
+
+func newAEAD(key []byte) cipher.AEAD {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		panic("rudp: invalid encryption key, " + err.Error())
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		panic("rudp: " + err.Error())
+	}
+	return aead
+}
+
+// deriveSealKey returns the AES key of the RUDP object sending with salt
+// in session, as long as key
+func deriveSealKey(key []byte, salt []byte, session []byte) []byte {
+	return hkdfExpand(hkdfExtract(salt, key), sealInfo+string(session), len(key))
+}
+
+func newSealer(sendKey []byte, recvKey []byte, opts Options) *sealer {
+	s := &sealer{session: append([]byte(nil), opts.SessionID...), recvKey: recvKey}
+	s.shared = bytes.Equal(sendKey, recvKey)
+	if opts.Salt != nil {
+		if len(opts.Salt) != sealSaltSize {
+			panic("rudp: salt must be 16 bytes")
+		}
+		copy(s.salt[:], opts.Salt)
+	} else if _, err := rand.Read(s.salt[:]); err != nil {
+		panic("rudp: " + err.Error())
+	}
+	s.sendAEAD = newAEAD(deriveSealKey(sendKey, s.salt[:], s.session))
+	if _, err := aes.NewCipher(recvKey); err != nil {
+		panic("rudp: invalid encryption key, " + err.Error())
+	}
+	if opts.PeerSalt != nil {
+		if len(opts.PeerSalt) != sealSaltSize {
+			panic("rudp: salt must be 16 bytes")
+		}
+		s.pin(opts.PeerSalt)
+	}
+	return s
+}
+
+// pin makes salt the only one packages are accepted from
+func (s *sealer) pin(salt []byte) {
+	copy(s.peerSalt[:], salt)
+	s.recvAEAD = newAEAD(deriveSealKey(s.recvKey, salt, s.session))
+	s.pinned = true
+}
+
+// seal replaces every package with its encrypted form
+func (s *sealer) seal(p *RUDPPackage) *RUDPPackage {
+	tmp := &tmpBuffer{}
+	for ; p != nil; p = p.Next {
+		binary.BigEndian.PutUint64(s.nonce[sealNonceSize-sealNumberSize:], s.counter)
+		q := tmp.createEmptyPackage(sealOverhead + p.Size)
+		copy(q.Buffer, s.salt[:])
+		binary.BigEndian.PutUint64(q.Buffer[sealSaltSize:], s.counter)
+		s.counter++
+		s.sendAEAD.Seal(q.Buffer[sealHeaderSize:sealHeaderSize], s.nonce[:], p.Buffer[:p.Size], nil)
+	}
+	return tmp.head
+}
+
+// openPackage authenticates, decrypts and replay checks a received package,
+// the returned buffer is only valid until the next call
+func (u *RUDP) openPackage(buffer []byte) ([]byte, bool) {
+	s := u.sealer
+	if len(buffer) < sealOverhead {
+		u.stats.Rejected++
+		return nil, false
+	}
+	salt := buffer[:sealSaltSize]
+	own := bytes.Equal(salt, s.salt[:])
+	aead := s.recvAEAD
+	switch {
+	case own && s.shared:
+		// our own package reflected back, authentic under the shared key
+		aead = s.sendAEAD
+	case own || (s.pinned && !bytes.Equal(salt, s.peerSalt[:])):
+		u.stats.Rejected++
+		return nil, false
+	case !s.pinned:
+		aead = newAEAD(deriveSealKey(s.recvKey, salt, s.session))
+	}
+	var nonce [sealNonceSize]byte
+	copy(nonce[sealNonceSize-sealNumberSize:], buffer[sealSaltSize:sealHeaderSize])
+	plain, err := aead.Open(s.plain[:0], nonce[:], buffer[sealHeaderSize:], nil)
+	if err != nil {
+		u.stats.Rejected++
+		return nil, false
+	}
+	s.plain = plain
+	if own {
+		u.stats.Replayed++
+		return nil, false
+	}
+	if !s.pinned {
+		copy(s.peerSalt[:], salt)
+		s.recvAEAD = aead
+		s.pinned = true
+	}
+
+	pn := binary.BigEndian.Uint64(nonce[sealNonceSize-sealNumberSize:])
+	if !s.replay.accept(pn) {
+		u.stats.Replayed++
+		return nil, false
+	}
+	return plain, true
+}
+
+// accept marks pn as received, false is returned if it was received
+// before or is too old to tell
+func (w *replayWindow) accept(pn uint64) bool {
+	if !w.started {
+		w.started = true
+		w.newest = pn
+		w.seen = 1
+		return true
+	}
+	if pn > w.newest {
+		shift := pn - w.newest
+		if shift >= replayWindowSize {
+			w.seen = 0
+		} else {
+			w.seen <<= shift
+		}
+		w.seen |= 1
+		w.newest = pn
+		return true
+	}
+	behind := w.newest - pn
+	if behind >= replayWindowSize || w.seen&(1<<behind) != 0 {
+		return false
+	}
+	w.seen |= 1 << behind
+	return true
+}
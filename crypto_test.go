@@ -0,0 +1,185 @@
+package rudp_test
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/bennychen/rudp"
+)
+
+func TestEncryption(t *testing.T) {
+	fmt.Println("=======================TestEncryption======================")
+
+	idx = 0
+	key := []byte("0123456789abcdef")
+	A := rudp.CreateWithOptions(1, 5, 128, rudp.Options{EncryptionKey: key})
+	B := rudp.CreateWithOptions(1, 5, 128, rudp.Options{EncryptionKey: key})
+	C := rudp.CreateWithOptions(1, 5, 128,
+		rudp.Options{EncryptionKey: []byte("fedcba9876543210")})
+
+	A.Send([]byte{1, 2, 3, 4}, 4)
+	p := A.Update(nil, 0, 1)
+	if p == nil || p.Next != nil || p.Size != 16+8+7+16 ||
+		bytes.Contains(p.Buffer, []byte{1, 2, 3, 4}) {
+		t.Error("RUDP::Update error, should send 1 encrypted package.")
+	}
+	dump(p)
+
+	forged := append([]byte(nil), p.Buffer...)
+	forged[30] ^= 1
+	B.Update(forged, len(forged), 0)
+	C.Update(p.Buffer, p.Size, 0)
+	B.Update(p.Buffer[:30], 30, 0)
+	if dumpRecv(B) != "" || dumpRecv(C) != "" {
+		t.Error("RUDP::Recv error, should receive nothing from forged packages.")
+	}
+	if B.Stats().Rejected != 2 || C.Stats().Rejected != 1 {
+		t.Error("RUDP::Stats error, forged packages should be rejected.")
+	}
+
+	B.Update(p.Buffer, p.Size, 0)
+	if dumpRecv(B) != "RECV 1 2 3 4\n" {
+		t.Error("RUDP::Recv error, should receive the decrypted message.")
+	}
+
+	// both sides share the key, they must still seal under different salts
+	q := B.Update(nil, 0, 1)
+	if q == nil || bytes.Compare(q.Buffer[:16], p.Buffer[:16]) == 0 {
+		t.Error("RUDP::Update error, peers should not share a salt.")
+	}
+}
+
+func TestSealKeys(t *testing.T) {
+	fmt.Println("=======================TestSealKeys======================")
+
+	// many objects share the key and all send packet number 0, each must
+	// seal under a key of its own
+	key := []byte("0123456789abcdef")
+	seen := make(map[string]bool)
+	for i := 0; i < 100; i++ {
+		A := rudp.CreateWithOptions(1, 5, 128, rudp.Options{EncryptionKey: key})
+		A.Send([]byte{1, 2, 3, 4}, 4)
+		p := A.Update(nil, 0, 1)
+		if !bytes.Equal(p.Buffer[16:24], make([]byte, 8)) {
+			t.Fatal("RUDP::Update error, first package should have packet number 0.")
+		}
+		sealed := string(p.Buffer[24:p.Size])
+		if seen[sealed] {
+			t.Fatal("RUDP::Update error, same message sealed the same way twice.")
+		}
+		seen[sealed] = true
+
+		B := rudp.CreateWithOptions(1, 5, 128, rudp.Options{EncryptionKey: key})
+		B.Update(p.Buffer, p.Size, 0)
+		if n := B.Recv(make([]byte, 4)); n != 4 {
+			t.Fatal("RUDP::Recv error, should open the package of any salt.")
+		}
+		p.Release()
+	}
+}
+
+func TestReplayProtection(t *testing.T) {
+	fmt.Println("=======================TestReplayProtection======================")
+
+	idx = 0
+	key := []byte("0123456789abcdef")
+	A := rudp.CreateWithOptions(1, 5, 128, rudp.Options{EncryptionKey: key})
+	B := rudp.CreateWithOptions(1, 5, 128, rudp.Options{EncryptionKey: key})
+
+	A.Send([]byte{1}, 1)
+	p1 := A.Update(nil, 0, 1)
+	A.Send([]byte{2}, 1)
+	p2 := A.Update(nil, 0, 1)
+	dump(p1)
+	dump(p2)
+
+	// reordered packages are fine, replays are not
+	B.Update(p2.Buffer, p2.Size, 0)
+	B.Update(p1.Buffer, p1.Size, 0)
+	B.Update(p1.Buffer, p1.Size, 0)
+	if dumpRecv(B) != "RECV 1\nRECV 2\n" || B.Stats().Replayed != 1 {
+		t.Error("RUDP::Update error, replayed package should be dropped.")
+	}
+
+	// a replayed control frame is not handled twice
+	r := B.Update(nil, 0, 1) // heartbeat requesting id 2
+	dump(r)
+	A.Update(r.Buffer, r.Size, 0)
+	A.Update(r.Buffer, r.Size, 0)
+	if A.Stats().Replayed != 1 {
+		t.Error("RUDP::Stats error, replayed heartbeat should be dropped.")
+	}
+
+	// packages reflected back under the shared key are dropped
+	A.Update(p2.Buffer, p2.Size, 0)
+	if A.Stats().Replayed != 2 || A.Stats().Rejected != 0 {
+		t.Error("RUDP::Stats error, reflected package should be dropped.")
+	}
+
+	// packages too far behind the newest one are dropped
+	for i := 0; i < 64; i++ {
+		p := A.Update(nil, 0, 1)
+		B.Update(p.Buffer, p.Size, 0)
+	}
+	B.Update(p2.Buffer, p2.Size, 0)
+	if B.Stats().Replayed != 2 {
+		t.Error("RUDP::Stats error, old package should be dropped.")
+	}
+}
+
+func TestSessionReplay(t *testing.T) {
+	fmt.Println("=======================TestSessionReplay======================")
+
+	idx = 0
+	key := []byte("0123456789abcdef")
+	create := func(opts rudp.Options) *rudp.RUDP {
+		opts.EncryptionKey = key
+		return rudp.CreateWithOptions(1, 5, 128, opts)
+	}
+
+	// the first authentic package pins the salt of the peer, packages of
+	// other salts never make it forget its replay state
+	A, B := create(rudp.Options{}), create(rudp.Options{})
+	A.Send([]byte{1}, 1)
+	p := A.Update(nil, 0, 1)
+	B.Update(p.Buffer, p.Size, 0)
+	for i := 0; i < 8; i++ {
+		q := create(rudp.Options{}).Update(nil, 0, 1)
+		B.Update(q.Buffer, q.Size, 0)
+	}
+	B.Update(p.Buffer, p.Size, 0)
+	if dumpRecv(B) != "RECV 1\n" || B.Stats().Rejected != 8 || B.Stats().Replayed != 1 {
+		t.Error("RUDP::Update error, replay should be dropped after other salts.")
+	}
+
+	// a SessionID makes a package recorded in an earlier session fail
+	// authentication, it pins nothing
+	p0 := create(rudp.Options{SessionID: []byte("1")}).Update(nil, 0, 1)
+	A = create(rudp.Options{SessionID: []byte("2")})
+	B = create(rudp.Options{SessionID: []byte("2")})
+	B.Update(p0.Buffer, p0.Size, 0)
+	A.Send([]byte{1}, 1)
+	p = A.Update(nil, 0, 1)
+	B.Update(p.Buffer, p.Size, 0)
+	if dumpRecv(B) != "RECV 1\n" || B.Stats().Rejected != 1 {
+		t.Error("RUDP::Recv error, package of another session should be rejected.")
+	}
+
+	// salts exchanged at setup reject the old package by its salt alone
+	p0 = create(rudp.Options{}).Update(nil, 0, 1)
+	saltA := bytes.Repeat([]byte{0xa}, 16)
+	saltB := bytes.Repeat([]byte{0xb}, 16)
+	A = create(rudp.Options{Salt: saltA, PeerSalt: saltB})
+	B = create(rudp.Options{Salt: saltB, PeerSalt: saltA})
+	B.Update(p0.Buffer, p0.Size, 0)
+	A.Send([]byte{1}, 1)
+	p = A.Update(nil, 0, 1)
+	if !bytes.Equal(p.Buffer[:16], saltA) {
+		t.Error("RUDP::Update error, should seal with the given salt.")
+	}
+	B.Update(p.Buffer, p.Size, 0)
+	if dumpRecv(B) != "RECV 1\n" || B.Stats().Rejected != 1 {
+		t.Error("RUDP::Recv error, package of an unknown salt should be rejected.")
+	}
+}
@@ -104,6 +104,77 @@ public static class RudpHelper
   }
 }
 
+// RudpCapabilities is the capabilities message peers exchange before
+// creating their Rudp objects, so a Go peer does not turn on features
+// changing the wire format. This port only speaks the original format, it
+// offers no optional features.
+// | type (1 byte) | version (1 byte) | max message size (2 bytes) |
+// | features (1 byte) | required (1 byte) |
+// | fec group size (1 byte) | fec mode (1 byte) | fec parity shards (1 byte) |
+public class RudpCapabilities
+{
+  public const int ProtocolVersion = 1;
+  public const int TypeCapabilities = 4;
+  public const int Size = 9;
+
+  public int Version = ProtocolVersion;
+  public int MaxMessageSize = Rudp.MaxPackageSize - Rudp.TypeNormal;
+  public int Features;
+  public int Required;
+  public int FECGroupSize;
+  public int FECMode;
+  public int FECParityShards;
+
+  // Marshal returns the message to send to the peer
+  public byte[] Marshal()
+  {
+    var b = new byte[Size];
+    b[0] = TypeCapabilities;
+    b[1] = (byte)Version;
+    RudpHelper.PutUshort(b, 2, (ushort)MaxMessageSize);
+    b[4] = (byte)Features;
+    b[5] = (byte)Required;
+    b[6] = (byte)FECGroupSize;
+    b[7] = (byte)FECMode;
+    b[8] = (byte)FECParityShards;
+    return b;
+  }
+
+  // Parse reads the message of the peer, null is returned if it is malformed
+  public static RudpCapabilities Parse(byte[] buffer, int sz)
+  {
+    if (sz != Size || buffer.Length < Size || buffer[0] != TypeCapabilities)
+    {
+      return null;
+    }
+    var c = new RudpCapabilities();
+    c.Version = buffer[1];
+    c.MaxMessageSize = RudpHelper.GetUshort(buffer, 2);
+    c.Features = buffer[4];
+    c.Required = buffer[5];
+    c.FECGroupSize = buffer[6];
+    c.FECMode = buffer[7];
+    c.FECParityShards = buffer[8];
+    return c;
+  }
+
+  // Negotiate returns the max message size both peers agree on, 0 if the
+  // peer speaks another version or requires a feature of the Go package
+  public static int Negotiate(RudpCapabilities local, RudpCapabilities remote)
+  {
+    if (local.Version != remote.Version)
+    {
+      return 0;
+    }
+    int features = local.Features & remote.Features;
+    if (((local.Required | remote.Required) & ~features) != 0)
+    {
+      return 0;
+    }
+    return Math.Max(0, Math.Min(local.MaxMessageSize, remote.MaxMessageSize));
+  }
+}
+
 public class Rudp
 {
   public const int MaxPackageSize = 1200;
@@ -113,6 +184,11 @@ public class Rudp
   public const int TypeMissing = 3;         // provider tells consumer that some message is missing
   public const int TypeNormal = 4;          // provider sends normal message to consumer
 
+  // MaxMessageSize is the largest message sent or accepted, set it to the
+  // size agreed by RudpCapabilities.Negotiate. A larger message from the
+  // peer corrupts the connection.
+  public int MaxMessageSize = MaxPackageSize - TypeNormal;
+
   public Rudp(int sendDelay, int expiredTime, int mtu)
   {
     _mtu = mtu;
@@ -133,7 +209,7 @@ public class Rudp
   // Send sends a new package out
   public void Send(byte[] buffer, int sz)
   {
-    if (sz > MaxPackageSize - TypeNormal)
+    if (sz > MaxMessageSize)
     {
       System.Console.WriteLine("package size is too large.");
       return;
@@ -347,7 +423,7 @@ public class Rudp
             // | tag (1~2 bytes) | id (2 bytes) | data |
             // data is at least 1 byte, so general msg's tag starts from 1
             int dataLength = tag - TypeNormal;
-            if (sz < dataLength + 2)
+            if (dataLength > MaxMessageSize || sz < dataLength + 2)
             {
               _corrupt = true;
               return;
@@ -0,0 +1,226 @@
+package rudp
+
+import "encoding/binary"
+
+// Forward error correction works on whole packages. With FEC enabled every
+// package is prefixed with
+// | group (2 bytes) | index (1 byte) |
+// indexes below the group size mark data packages carrying the original
+// package, the following indexes mark the parity packages of the group.
+// Parity is computed over the data packages of the group, each padded as
+// | length (2 bytes) | data | zeros |
+// so a receiver missing some data packages rebuilds them from the rest
+// without waiting for TypeRequest and a resend. FECXOR sends a single XOR
+// parity package and recovers one loss per group, FECReedSolomon sends
+// FECParityShards parity packages and recovers as many losses.
+
+const (
+	FECXOR         = iota // one XOR parity package per group
+	FECReedSolomon        // Options.FECParityShards Reed-Solomon parity packages per group
+)
+
+const (
+	fecHeaderSize  = 3
+	fecShardHeader = 2
+	fecMaxShards   = 255 // data and parity indexes share one byte
+	fecGroupSlots  = 16  // recent groups a receiver keeps for recovery
+)
+
+type fecEncoder struct {
+	codec  *fecCodec
+	group  uint16
+	count  int
+	shards [][]byte // | length | data | of the current group
+	parity [][]byte
+}
+
+type fecGroup struct {
+	group     uint16
+	used      bool
+	received  []bool // data shards followed by parity shards
+	nReceived int
+	shards    [][]byte
+}
+
+type fecDecoder struct {
+	codec  *fecCodec
+	groups [fecGroupSlots]fecGroup
+}
+
+// newFECCodec clamps the FEC options into a codec, nil means FEC is off
+func newFECCodec(opts Options) *fecCodec {
+	dataShards, mode, parityShards := fecSettings(opts)
+	if dataShards == 0 {
+		return nil
+	}
+	if mode != FECReedSolomon {
+		return newXORCodec(dataShards)
+	}
+	return newReedSolomonCodec(dataShards, parityShards)
+}
+
+// fecSettings returns the FEC options as the codec uses them, clamped to
+// what fits the header. 0 data shards means FEC is off
+func fecSettings(opts Options) (dataShards int, mode int, parityShards int) {
+	if opts.FECGroupSize <= 0 {
+		return 0, FECXOR, 0
+	}
+	dataShards = opts.FECGroupSize
+	if dataShards > fecMaxShards-1 {
+		dataShards = fecMaxShards - 1
+	}
+	if opts.FECMode != FECReedSolomon {
+		return dataShards, FECXOR, 0
+	}
+	parityShards = opts.FECParityShards
+	if parityShards < 1 {
+		parityShards = 1
+	}
+	if dataShards+parityShards > fecMaxShards {
+		parityShards = fecMaxShards - dataShards
+	}
+	return dataShards, FECReedSolomon, parityShards
+}
+
+func newFECEncoder(codec *fecCodec) *fecEncoder {
+	return &fecEncoder{
+		codec:  codec,
+		shards: make([][]byte, codec.dataShards),
+		parity: make([][]byte, codec.parityShards),
+	}
+}
+
+func newFECDecoder(codec *fecCodec) *fecDecoder {
+	return &fecDecoder{codec: codec}
+}
+
+// encode prefixes every package with the FEC header and appends the parity
+// packages each time a group is complete
+func (e *fecEncoder) encode(p *RUDPPackage) *RUDPPackage {
+	tmp := &tmpBuffer{}
+	for ; p != nil; p = p.Next {
+		data := tmp.createEmptyPackage(fecHeaderSize + p.Size)
+		e.fillHeader(data.Buffer, e.count)
+		copy(data.Buffer[fecHeaderSize:], p.Buffer[:p.Size])
+		e.shards[e.count] = appendShard(e.shards[e.count][:0], p.Buffer[:p.Size])
+		e.count++
+
+		if e.count == e.codec.dataShards {
+			size := e.codec.encode(e.shards, e.parity)
+			for j := range e.parity {
+				parity := tmp.createEmptyPackage(fecHeaderSize + size)
+				e.fillHeader(parity.Buffer, e.count+j)
+				copy(parity.Buffer[fecHeaderSize:], e.parity[j])
+			}
+			e.count = 0
+			e.group++
+		}
+	}
+	return tmp.head
+}
+
+func (e *fecEncoder) fillHeader(buffer []byte, index int) {
+	binary.BigEndian.PutUint16(buffer, e.group)
+	buffer[2] = byte(index)
+}
+
+func appendShard(shard []byte, data []byte) []byte {
+	shard = append(shard, byte(len(data)>>8), byte(len(data)))
+	return append(shard, data...)
+}
+
+// fecDecode extracts a received data package right away and keeps a copy of
+// it, so lost packages of the same group can be rebuilt once enough
+// packages of the group arrive
+func (u *RUDP) fecDecode(buffer []byte) {
+	d := u.fecDecoder
+	k := d.codec.dataShards
+	if len(buffer) < fecHeaderSize {
+		u.corrupt = true
+		return
+	}
+	group := binary.BigEndian.Uint16(buffer)
+	index := int(buffer[2])
+	payload := buffer[fecHeaderSize:]
+	if index >= k+d.codec.parityShards || (index >= k && len(payload) < fecShardHeader) {
+		u.corrupt = true
+		return
+	}
+
+	g := d.slot(group)
+	if g == nil {
+		// too old to recover anything, still deliver data
+		if index < k {
+			u.extractPayload(payload)
+		}
+		return
+	}
+	if g.received[index] {
+		// duplicated or already recovered
+		return
+	}
+	g.received[index] = true
+	g.nReceived++
+	if index < k {
+		g.shards[index] = appendShard(g.shards[index][:0], payload)
+		u.extractPayload(payload)
+	} else {
+		g.shards[index] = append(g.shards[index][:0], payload...)
+	}
+	u.fecRecover(g)
+}
+
+// fecRecover rebuilds the missing data packages of a group as soon as
+// enough of its packages are received
+func (u *RUDP) fecRecover(g *fecGroup) {
+	k := u.fecDecoder.codec.dataShards
+	if g.nReceived < k {
+		return
+	}
+	missing := 0
+	for i := 0; i < k; i++ {
+		if !g.received[i] {
+			missing++
+		}
+	}
+	if missing == 0 || !u.fecDecoder.codec.reconstruct(g.shards, g.received) {
+		return
+	}
+	for i := 0; i < k; i++ {
+		if g.received[i] {
+			continue
+		}
+		g.received[i] = true
+		g.nReceived++
+		shard := g.shards[i]
+		sz := int(binary.BigEndian.Uint16(shard))
+		if sz > len(shard)-fecShardHeader {
+			continue
+		}
+		u.stats.FECRecovered++
+		u.extractPayload(shard[fecShardHeader : fecShardHeader+sz])
+	}
+}
+
+// slot returns the state of group, or nil if the group is older than
+// every group still kept
+func (d *fecDecoder) slot(group uint16) *fecGroup {
+	g := &d.groups[group%fecGroupSlots]
+	if g.used && g.group == group {
+		return g
+	}
+	if g.used && int16(group-g.group) < 0 {
+		return nil
+	}
+	if g.received == nil {
+		g.received = make([]bool, d.codec.dataShards+d.codec.parityShards)
+		g.shards = make([][]byte, d.codec.dataShards+d.codec.parityShards)
+	}
+	for i := range g.received {
+		g.received[i] = false
+	}
+	g.group = group
+	g.used = true
+	g.nReceived = 0
+	return g
+}
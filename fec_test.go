@@ -0,0 +1,94 @@
+package rudp_test
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/bennychen/rudp"
+)
+
+func TestFECRecover(t *testing.T) {
+	fmt.Println("=======================TestFECRecover======================")
+
+	idx = 0
+	opts := rudp.Options{FECGroupSize: 2}
+	A := rudp.CreateWithOptions(1, 5, 128, opts)
+	B := rudp.CreateWithOptions(1, 5, 128, opts)
+
+	A.Send([]byte{1, 2, 3}, 3)
+	p0 := A.Update(nil, 0, 1)
+	if p0 == nil || p0.Next != nil ||
+		bytes.Compare(p0.Buffer, []byte{0, 0, 0, 7, 0, 0, 1, 2, 3}) != 0 {
+		t.Error("RUDP::Update error, should send 1 data package of group 0.")
+	}
+	dump(p0)
+
+	A.Send([]byte{4, 5}, 2)
+	p1 := A.Update(nil, 0, 1)
+	if p1 == nil || p1.Next == nil || p1.Next.Next != nil ||
+		bytes.Compare(p1.Buffer, []byte{0, 0, 1, 6, 0, 1, 4, 5}) != 0 ||
+		bytes.Compare(p1.Next.Buffer, []byte{0, 0, 2, 0, 3, 1, 0, 1, 5, 7, 3}) != 0 {
+		t.Error("RUDP::Update error, should send 1 data and 1 parity package.")
+	}
+	dump(p1)
+
+	// p0 is lost
+	B.Update(p1.Buffer, p1.Size, 0)
+	B.Update(p1.Next.Buffer, p1.Next.Size, 0)
+	recvResult := dumpRecv(B)
+	if recvResult != "RECV 1 2 3\nRECV 4 5\n" {
+		t.Error("RUDP::Recv error, lost message should be rebuilt from parity.")
+	}
+	if B.Stats().FECRecovered != 1 {
+		t.Error("RUDP::Stats error, should recover 1 package.")
+	}
+
+	// late arrival of the recovered package is dropped
+	B.Update(p0.Buffer, p0.Size, 0)
+	if dumpRecv(B) != "" {
+		t.Error("RUDP::Recv error, should receive nothing.")
+	}
+
+	r := []byte{0, 0}
+	B.Update(r, len(r), 0)
+	if dumpRecv(B) != "CORRUPT\n" {
+		t.Error("Should get a corrupt signal for a truncated FEC header.")
+	}
+}
+
+func TestFECReedSolomon(t *testing.T) {
+	fmt.Println("=======================TestFECReedSolomon======================")
+
+	idx = 0
+	opts := rudp.Options{
+		FECGroupSize:    3,
+		FECMode:         rudp.FECReedSolomon,
+		FECParityShards: 2,
+	}
+	A := rudp.CreateWithOptions(1, 5, 128, opts)
+	B := rudp.CreateWithOptions(1, 5, 128, opts)
+
+	A.Send([]byte{1, 2, 3}, 3)
+	dump(A.Update(nil, 0, 1)) // lost
+	A.Send([]byte{4, 5}, 2)
+	dump(A.Update(nil, 0, 1)) // lost
+	A.Send([]byte{6}, 1)
+	p := A.Update(nil, 0, 1)
+	dump(p)
+	if p == nil || p.Next == nil || p.Next.Next == nil || p.Next.Next.Next != nil ||
+		p.Buffer[2] != 2 || p.Next.Buffer[2] != 3 || p.Next.Next.Buffer[2] != 4 {
+		t.Error("RUDP::Update error, should send 1 data and 2 parity packages.")
+	}
+
+	for ; p != nil; p = p.Next {
+		B.Update(p.Buffer, p.Size, 0)
+	}
+	recvResult := dumpRecv(B)
+	if recvResult != "RECV 1 2 3\nRECV 4 5\nRECV 6\n" {
+		t.Error("RUDP::Recv error, 2 lost messages should be rebuilt from parity.")
+	}
+	if B.Stats().FECRecovered != 2 || B.Stats().Retransmitted != 0 {
+		t.Error("RUDP::Stats error, should recover 2 packages.")
+	}
+}
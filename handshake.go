@@ -0,0 +1,226 @@
+package rudp
+
+import (
+	"crypto/ecdh"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"net"
+)
+
+// The handshake is an ephemeral X25519 key exchange, like RUDP itself it
+// only produces and consumes bytes, the caller carries them over UDP.
+//
+//	client hello: | type (1 byte) | flags (1 byte) | client public key (32 bytes) |
+//	              | cookie length (1 byte) | cookie |
+//	hello verify: | type (1 byte) | cookie length (1 byte) | cookie |
+//	server hello: | type (1 byte) | server public key (32 bytes) | confirm (32 bytes) |
+//
+// A server requiring cookies answers a hello without a valid cookie with a
+// hello verify and forgets about it, the client then sends its hello again
+// with the cookie.
+//
+// Session keys are derived with HKDF-SHA256 from the ephemeral shared
+// secret, plus the shared secret of the client ephemeral key and the server
+// static key when the client knows the server static public key. Only the
+// real server can then produce the confirm MAC over both public keys.
+
+const (
+	handshakeClientHello = 1
+	handshakeServerHello = 2
+	handshakeHelloVerify = 3
+
+	handshakeFlagServerAuth = 1
+
+	handshakeKeySize     = 32
+	handshakeClientSize  = 3 + handshakeKeySize // without cookie
+	handshakeServerSize  = 1 + handshakeKeySize + sha256.Size
+	handshakeInfoClient  = "rudp client to server"
+	handshakeInfoServer  = "rudp server to client"
+	handshakeInfoConfirm = "rudp confirm"
+)
+
+var (
+	ErrHandshake  = errors.New("rudp: malformed handshake message")
+	ErrServerAuth = errors.New("rudp: server authentication failed")
+	ErrNoStatic   = errors.New("rudp: server has no static key to authenticate with")
+
+	// ErrHelloVerify is returned by ClientHandshake.Finish when the server
+	// asks for the hello to be sent again with a cookie
+	ErrHelloVerify = errors.New("rudp: server requires a cookie, send hello again")
+)
+
+// SessionKeys are the per direction AES-256 keys derived by a handshake,
+// use them as Options.SendKey and Options.RecvKey
+type SessionKeys struct {
+	Send []byte
+	Recv []byte
+}
+
+// ClientHandshake is the client side of a key exchange
+type ClientHandshake struct {
+	private      *ecdh.PrivateKey
+	serverStatic *ecdh.PublicKey
+	cookie       []byte
+}
+
+// NewClientHandshake starts a key exchange, serverStatic may be nil to skip
+// server authentication
+func NewClientHandshake(serverStatic *ecdh.PublicKey) (*ClientHandshake, error) {
+	private, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	return &ClientHandshake{private: private, serverStatic: serverStatic}, nil
+}
+
+// Hello returns the message to send to the server
+func (h *ClientHandshake) Hello() []byte {
+	hello := make([]byte, 0, handshakeClientSize+len(h.cookie))
+	hello = append(hello, handshakeClientHello, 0)
+	if h.serverStatic != nil {
+		hello[1] |= handshakeFlagServerAuth
+	}
+	hello = append(hello, h.private.PublicKey().Bytes()...)
+	hello = append(hello, byte(len(h.cookie)))
+	return append(hello, h.cookie...)
+}
+
+// Finish checks the server reply and returns the session keys
+func (h *ClientHandshake) Finish(reply []byte) (*SessionKeys, error) {
+	if len(reply) >= 2 && reply[0] == handshakeHelloVerify {
+		if len(reply) != 2+int(reply[1]) {
+			return nil, ErrHandshake
+		}
+		h.cookie = append(h.cookie[:0], reply[2:]...)
+		return nil, ErrHelloVerify
+	}
+	if len(reply) != handshakeServerSize || reply[0] != handshakeServerHello {
+		return nil, ErrHandshake
+	}
+	serverPublic, err := ecdh.X25519().NewPublicKey(reply[1 : 1+handshakeKeySize])
+	if err != nil {
+		return nil, ErrHandshake
+	}
+	ikm, err := h.private.ECDH(serverPublic)
+	if err != nil {
+		return nil, ErrHandshake
+	}
+	if h.serverStatic != nil {
+		static, err := h.private.ECDH(h.serverStatic)
+		if err != nil {
+			return nil, ErrServerAuth
+		}
+		ikm = append(ikm, static...)
+	}
+	k := deriveHandshakeKeys(ikm, h.private.PublicKey().Bytes(), serverPublic.Bytes())
+	if !hmac.Equal(k.confirm, reply[1+handshakeKeySize:]) {
+		return nil, ErrServerAuth
+	}
+	return &SessionKeys{Send: k.clientToServer, Recv: k.serverToClient}, nil
+}
+
+// ServerHandshake answers client hellos, it keeps no per client state
+type ServerHandshake struct {
+	static  *ecdh.PrivateKey
+	cookies *CookieJar
+}
+
+// NewServerHandshake creates the server side of key exchanges, static may be
+// nil if clients are not going to authenticate the server
+func NewServerHandshake(static *ecdh.PrivateKey) *ServerHandshake {
+	return &ServerHandshake{static: static}
+}
+
+// RequireCookie makes Accept challenge hellos without a valid cookie
+func (s *ServerHandshake) RequireCookie(jar *CookieJar) {
+	s.cookies = jar
+}
+
+// Accept handles a client hello from addr, the reply goes back to the client
+// and the keys are for the RUDP object of that client. Keys are nil when the
+// reply is a cookie challenge, no state should be created for addr then.
+func (s *ServerHandshake) Accept(addr net.Addr, hello []byte) ([]byte, *SessionKeys, error) {
+	if !isClientHello(hello) {
+		return nil, nil, ErrHandshake
+	}
+	if s.cookies != nil && !s.cookies.Verify(addr, hello[handshakeClientSize:]) {
+		cookie := s.cookies.Cookie(addr)
+		reply := append([]byte{handshakeHelloVerify, byte(len(cookie))}, cookie...)
+		return reply, nil, nil
+	}
+	clientPublic, err := ecdh.X25519().NewPublicKey(hello[2 : 2+handshakeKeySize])
+	if err != nil {
+		return nil, nil, ErrHandshake
+	}
+	private, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	ikm, err := private.ECDH(clientPublic)
+	if err != nil {
+		return nil, nil, ErrHandshake
+	}
+	if hello[1]&handshakeFlagServerAuth != 0 {
+		if s.static == nil {
+			return nil, nil, ErrNoStatic
+		}
+		static, err := s.static.ECDH(clientPublic)
+		if err != nil {
+			return nil, nil, ErrHandshake
+		}
+		ikm = append(ikm, static...)
+	}
+	k := deriveHandshakeKeys(ikm, clientPublic.Bytes(), private.PublicKey().Bytes())
+
+	reply := make([]byte, 0, handshakeServerSize)
+	reply = append(reply, handshakeServerHello)
+	reply = append(reply, private.PublicKey().Bytes()...)
+	reply = append(reply, k.confirm...)
+	return reply, &SessionKeys{Send: k.serverToClient, Recv: k.clientToServer}, nil
+}
+
+func isClientHello(b []byte) bool {
+	return len(b) >= handshakeClientSize && b[0] == handshakeClientHello &&
+		len(b) == handshakeClientSize+int(b[handshakeClientSize-1])
+}
+
+type handshakeKeys struct {
+	clientToServer []byte
+	serverToClient []byte
+	confirm        []byte // MAC over both public keys
+}
+
+func deriveHandshakeKeys(ikm []byte, clientPublic []byte, serverPublic []byte) handshakeKeys {
+	transcript := append(append([]byte(nil), clientPublic...), serverPublic...)
+	prk := hkdfExtract(transcript, ikm)
+	mac := hmac.New(sha256.New, hkdfExpand(prk, handshakeInfoConfirm, sha256.Size))
+	mac.Write(transcript)
+	return handshakeKeys{
+		clientToServer: hkdfExpand(prk, handshakeInfoClient, handshakeKeySize),
+		serverToClient: hkdfExpand(prk, handshakeInfoServer, handshakeKeySize),
+		confirm:        mac.Sum(nil),
+	}
+}
+
+// hkdfExtract and hkdfExpand implement HKDF-SHA256 from RFC 5869
+func hkdfExtract(salt []byte, ikm []byte) []byte {
+	mac := hmac.New(sha256.New, salt)
+	mac.Write(ikm)
+	return mac.Sum(nil)
+}
+
+func hkdfExpand(prk []byte, info string, length int) []byte {
+	out := make([]byte, 0, length+sha256.Size)
+	var block []byte
+	for i := byte(1); len(out) < length; i++ {
+		mac := hmac.New(sha256.New, prk)
+		mac.Write(block)
+		mac.Write([]byte(info))
+		mac.Write([]byte{i})
+		block = mac.Sum(nil)
+		out = append(out, block...)
+	}
+	return out[:length]
+}
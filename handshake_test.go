@@ -0,0 +1,89 @@
+package rudp_test
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/rand"
+	"fmt"
+	"testing"
+
+	"github.com/bennychen/rudp"
+)
+
+func TestHandshake(t *testing.T) {
+	fmt.Println("=======================TestHandshake======================")
+
+	static, _ := ecdh.X25519().GenerateKey(rand.Reader)
+	server := rudp.NewServerHandshake(static)
+
+	client, err := rudp.NewClientHandshake(static.PublicKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	reply, serverKeys, err := server.Accept(nil, client.Hello())
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientKeys, err := client.Finish(reply)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Compare(clientKeys.Send, serverKeys.Recv) != 0 ||
+		bytes.Compare(clientKeys.Recv, serverKeys.Send) != 0 ||
+		bytes.Compare(clientKeys.Send, clientKeys.Recv) == 0 {
+		t.Error("Handshake error, both sides should derive per direction keys.")
+	}
+
+	A := rudp.CreateWithOptions(1, 5, 128,
+		rudp.Options{SendKey: clientKeys.Send, RecvKey: clientKeys.Recv})
+	B := rudp.CreateWithOptions(1, 5, 128,
+		rudp.Options{SendKey: serverKeys.Send, RecvKey: serverKeys.Recv})
+	A.Send([]byte{1, 2, 3}, 3)
+	p := A.Update(nil, 0, 1)
+	B.Update(p.Buffer, p.Size, 0)
+	if dumpRecv(B) != "RECV 1 2 3\n" {
+		t.Error("RUDP::Recv error, should receive with session keys.")
+	}
+	// a package does not decrypt with the key of the other direction
+	A.Update(p.Buffer, p.Size, 0)
+	if A.Stats().Rejected != 1 {
+		t.Error("RUDP::Stats error, own package should be rejected.")
+	}
+}
+
+func TestHandshakeServerAuth(t *testing.T) {
+	fmt.Println("=======================TestHandshakeServerAuth======================")
+
+	static, _ := ecdh.X25519().GenerateKey(rand.Reader)
+	impostor, _ := ecdh.X25519().GenerateKey(rand.Reader)
+
+	client, _ := rudp.NewClientHandshake(static.PublicKey())
+	reply, _, err := rudp.NewServerHandshake(impostor).Accept(nil, client.Hello())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Finish(reply); err != rudp.ErrServerAuth {
+		t.Error("Handshake error, impostor server should fail authentication.")
+	}
+
+	if _, _, err := rudp.NewServerHandshake(nil).Accept(nil, client.Hello()); err != rudp.ErrNoStatic {
+		t.Error("Handshake error, server without static key cannot authenticate.")
+	}
+
+	// without server authentication any server is accepted
+	client, _ = rudp.NewClientHandshake(nil)
+	reply, _, err = rudp.NewServerHandshake(nil).Accept(nil, client.Hello())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Finish(reply); err != nil {
+		t.Error("Handshake error, unauthenticated exchange should succeed.")
+	}
+
+	if _, _, err := rudp.NewServerHandshake(nil).Accept(nil, []byte{1, 0}); err != rudp.ErrHandshake {
+		t.Error("Handshake error, truncated hello should be rejected.")
+	}
+	if _, err := client.Finish(reply[:10]); err != rudp.ErrHandshake {
+		t.Error("Handshake error, truncated reply should be rejected.")
+	}
+}
@@ -0,0 +1,254 @@
+package rudp
+
+import (
+	"context"
+	"net"
+	"runtime"
+	"sync"
+	"time"
+)
+
+const (
+	defaultBatchSize     = 64
+	defaultSendDelay     = 10 * time.Millisecond
+	defaultExpiredTime   = 5 * time.Second
+	defaultMTU           = 512
+	defaultAcceptBacklog = 128
+)
+
+// ListenConfig configures a Listener
+type ListenConfig struct {
+	// Shards is the number of sockets bound to the address with
+	// SO_REUSEPORT, each read by its own goroutine. 0 means one per CPU,
+	// it is always 1 outside Linux.
+	Shards int
+
+	// BatchSize is the number of datagrams read or written per syscall,
+	// 0 means 64
+	BatchSize int
+
+	// Clock drives the send timers of the peers, nil means SystemClock
+	Clock Clock
+
+	// NewRUDP creates the RUDP object for the first datagram of an unknown
+	// peer, returning nil drops the datagram without keeping any state.
+	// nil means RUDP objects sending every 10 milliseconds and keeping
+	// history for 5 seconds
+	NewRUDP func(addr net.Addr, datagram []byte) *RUDP
+
+	// Handshake makes unknown peers run a key exchange before the Listener
+	// keeps any state for them. Their datagrams go to Handshake.Accept and
+	// the reply back to the peer, cookie challenges included, only a
+	// completed exchange creates the Conn. The handshake is never fed to
+	// the RUDP object, which NewSession creates from the session keys.
+	Handshake *ServerHandshake
+	// NewSession creates the RUDP object of a peer that completed the
+	// handshake, it must use the keys. nil means the default of NewRUDP
+	// with the keys as SendKey and RecvKey
+	NewSession func(addr net.Addr, keys *SessionKeys) *RUDP
+}
+
+// Listener serves many peers on one UDP address. Every shard socket has its
+// own peer table, the kernel keeps the datagrams of a peer on one shard.
+type Listener struct {
+	shards []*shard
+	accept chan *Conn
+	done   chan struct{}
+	once   sync.Once
+	wg     sync.WaitGroup
+}
+
+type shard struct {
+	l     *Listener
+	pc    net.PacketConn
+	batch *Batch
+	clock Clock
+	new   func(addr net.Addr, datagram []byte) *RUDP
+
+	handshake  *ServerHandshake
+	newSession func(addr net.Addr, keys *SessionKeys) *RUDP
+
+	mu    sync.Mutex
+	peers map[string]*Conn
+}
+
+// Listen opens the shard sockets on address and starts reading them
+func (lc ListenConfig) Listen(network, address string) (*Listener, error) {
+	n := lc.Shards
+	if n <= 0 {
+		n = runtime.NumCPU()
+	}
+	if !reusePortSupported {
+		n = 1
+	}
+	size := lc.BatchSize
+	if size <= 0 {
+		size = defaultBatchSize
+	}
+	newRUDP := lc.NewRUDP
+	if newRUDP == nil {
+		newRUDP = func(net.Addr, []byte) *RUDP {
+			return newListenerRUDP(Options{})
+		}
+	}
+
+	newSession := lc.NewSession
+	if newSession == nil {
+		newSession = func(addr net.Addr, keys *SessionKeys) *RUDP {
+			return newListenerRUDP(Options{SendKey: keys.Send, RecvKey: keys.Recv})
+		}
+	}
+
+	l := &Listener{
+		accept: make(chan *Conn, defaultAcceptBacklog),
+		done:   make(chan struct{}),
+	}
+	nlc := net.ListenConfig{Control: reusePort}
+	for i := 0; i < n; i++ {
+		pc, err := nlc.ListenPacket(context.Background(), network, address)
+		if err != nil {
+			for _, s := range l.shards {
+				s.pc.Close()
+			}
+			return nil, err
+		}
+		if i == 0 {
+			// the other shards bind the port picked for the first one
+			address = pc.LocalAddr().String()
+		}
+		l.shards = append(l.shards, &shard{
+			l:     l,
+			pc:    pc,
+			batch: NewBatch(pc, size),
+			clock: lc.Clock,
+			new:   newRUDP,
+			peers: make(map[string]*Conn),
+
+			handshake:  lc.Handshake,
+			newSession: newSession,
+		})
+	}
+	for _, s := range l.shards {
+		l.wg.Add(1)
+		go func(s *shard) {
+			defer l.wg.Done()
+			s.batch.ReadLoop(s.handle)
+		}(s)
+	}
+	return l, nil
+}
+
+// Accept waits for the next new peer, its Conn is fed by the listener so
+// Conn.ReadLoop must not be called
+func (l *Listener) Accept() (*Conn, error) {
+	select {
+	case <-l.done:
+		return nil, net.ErrClosed
+	default:
+	}
+	select {
+	case c := <-l.accept:
+		return c, nil
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+// Addr returns the address all shards are bound to
+func (l *Listener) Addr() net.Addr {
+	return l.shards[0].pc.LocalAddr()
+}
+
+// Close closes the shard sockets and the Conns of all peers
+func (l *Listener) Close() error {
+	l.once.Do(func() {
+		close(l.done)
+		for _, s := range l.shards {
+			s.pc.Close()
+		}
+		l.wg.Wait()
+		for _, s := range l.shards {
+			s.mu.Lock()
+			conns := make([]*Conn, 0, len(s.peers))
+			for _, c := range s.peers {
+				conns = append(conns, c)
+			}
+			s.mu.Unlock()
+			for _, c := range conns {
+				c.Close()
+			}
+		}
+	})
+	return nil
+}
+
+// handle is called by the read loop of the shard only
+func (s *shard) handle(datagram []byte, addr net.Addr) {
+	key := addr.String()
+	s.mu.Lock()
+	c := s.peers[key]
+	s.mu.Unlock()
+	if c != nil {
+		if c.hello != nil && isClientHello(datagram) && !c.heard() {
+			// the server hello was lost, a new exchange would change the keys
+			s.reply(c.hello, addr)
+			return
+		}
+		c.Input(datagram)
+		return
+	}
+
+	var u *RUDP
+	var hello []byte
+	if s.handshake != nil {
+		reply, keys, err := s.handshake.Accept(addr, datagram)
+		if err != nil {
+			return
+		}
+		s.reply(reply, addr)
+		if keys == nil {
+			// a cookie challenge, the peer is not verified yet
+			return
+		}
+		u = s.newSession(addr, keys)
+		hello = reply
+	} else {
+		u = s.new(addr, datagram)
+	}
+	if u == nil {
+		return
+	}
+	c = NewBatchedConn(s.batch, addr, u, s.clock)
+	c.hello = hello
+	c.onClose = func() {
+		s.mu.Lock()
+		if s.peers[key] == c {
+			delete(s.peers, key)
+		}
+		s.mu.Unlock()
+	}
+	s.mu.Lock()
+	s.peers[key] = c
+	s.mu.Unlock()
+	if hello == nil {
+		c.Input(datagram)
+	}
+	select {
+	case s.l.accept <- c:
+	default:
+		// the backlog is full, the peer will try again
+		c.Close()
+	}
+}
+
+// reply sends a handshake message to addr, it is not batched as it only
+// happens once per peer. A lost reply makes the peer send its hello again.
+func (s *shard) reply(b []byte, addr net.Addr) {
+	s.pc.WriteTo(b, addr)
+}
+
+// newListenerRUDP creates the default RUDP object of a peer, Conns drive it
+// in real time so its history must outlive the round trip of slow links
+func newListenerRUDP(opts Options) *RUDP {
+	return CreateWithDuration(defaultSendDelay, defaultExpiredTime, defaultMTU, opts)
+}
@@ -0,0 +1,187 @@
+package rudp_test
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/bennychen/rudp"
+)
+
+func newRealtimeRUDP() *rudp.RUDP {
+	return rudp.CreateWithDuration(time.Millisecond, time.Second, 512, rudp.Options{})
+}
+
+// waitRecv polls c until a message arrives
+func waitRecv(t *testing.T, c *rudp.Conn) []byte {
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		if m, err := c.RecvMessage(); err != nil {
+			t.Fatal("Conn error, connection should not be corrupt.")
+		} else if m != nil {
+			b := append([]byte(nil), m.Bytes()...)
+			m.Release()
+			return b
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatal("Conn error, no message arrived.")
+	return nil
+}
+
+func acceptTimeout(l *rudp.Listener, d time.Duration) *rudp.Conn {
+	ch := make(chan *rudp.Conn, 1)
+	go func() {
+		c, _ := l.Accept()
+		ch <- c
+	}()
+	select {
+	case c := <-ch:
+		return c
+	case <-time.After(d):
+		return nil
+	}
+}
+
+func dialListener(t *testing.T, l *rudp.Listener) *rudp.Conn {
+	pc := listenUDP(t)
+	t.Cleanup(func() { pc.Close() })
+	c := rudp.NewConn(pc, l.Addr(), newRealtimeRUDP(), nil)
+	t.Cleanup(func() { c.Close() })
+	go c.ReadLoop()
+	return c
+}
+
+func TestListener(t *testing.T) {
+	fmt.Println("=======================TestListener======================")
+
+	lc := rudp.ListenConfig{
+		Shards: 4,
+		Clock:  rudp.NewScheduler(time.Millisecond, nil),
+		NewRUDP: func(net.Addr, []byte) *rudp.RUDP {
+			return newRealtimeRUDP()
+		},
+	}
+	l, err := lc.Listen("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Skip("no loopback UDP:", err)
+	}
+	defer l.Close()
+
+	const peers = 8
+	clients := make([]*rudp.Conn, peers)
+	for i := range clients {
+		clients[i] = dialListener(t, l)
+		clients[i].Send([]byte{byte(i)})
+	}
+
+	accepted := make([]*rudp.Conn, peers)
+	for i := 0; i < peers; i++ {
+		c := acceptTimeout(l, 2*time.Second)
+		if c == nil {
+			t.Fatalf("Listener::Accept error, only %d of %d peers arrived.", i, peers)
+		}
+		msg := waitRecv(t, c)
+		accepted[msg[0]] = c
+	}
+
+	// later datagrams go to the accepted Conn of the peer
+	for i, c := range clients {
+		c.Send([]byte{byte(i + 100)})
+	}
+	for i, c := range accepted {
+		if msg := waitRecv(t, c); len(msg) != 1 || msg[0] != byte(i+100) {
+			t.Errorf("Listener error, peer %d sent %v.", i, msg)
+		}
+	}
+
+	l.Close()
+	if _, err := l.Accept(); err != net.ErrClosed {
+		t.Error("Listener::Accept error, should fail after Close.")
+	}
+}
+
+func TestListenerDrop(t *testing.T) {
+	fmt.Println("=======================TestListenerDrop======================")
+
+	var calls int32
+	lc := rudp.ListenConfig{
+		Shards: 1,
+		NewRUDP: func(addr net.Addr, datagram []byte) *rudp.RUDP {
+			atomic.AddInt32(&calls, 1)
+			return nil
+		},
+	}
+	l, err := lc.Listen("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Skip("no loopback UDP:", err)
+	}
+	defer l.Close()
+
+	c := dialListener(t, l)
+	c.Send([]byte{1})
+	if acceptTimeout(l, 50*time.Millisecond) != nil {
+		t.Error("Listener error, dropped peer should not be accepted.")
+	}
+	if atomic.LoadInt32(&calls) < 2 {
+		t.Error("Listener error, dropped peer should not keep any state.")
+	}
+}
+
+func TestListenerHandshake(t *testing.T) {
+	fmt.Println("=======================TestListenerHandshake======================")
+
+	hs := rudp.NewServerHandshake(nil)
+	hs.RequireCookie(rudp.NewCookieJar(time.Minute, nil))
+	lc := rudp.ListenConfig{
+		Shards:    1,
+		Handshake: hs,
+		// the server stays quiet, so only handshake replies reach the
+		// client before its Conn starts
+		NewSession: func(addr net.Addr, keys *rudp.SessionKeys) *rudp.RUDP {
+			return rudp.CreateWithDuration(time.Second, 5*time.Second, 512,
+				rudp.Options{SendKey: keys.Send, RecvKey: keys.Recv})
+		},
+	}
+	l, err := lc.Listen("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Skip("no loopback UDP:", err)
+	}
+	defer l.Close()
+
+	pc := listenUDP(t)
+	defer pc.Close()
+	ch, _ := rudp.NewClientHandshake(nil)
+	pc.WriteTo(ch.Hello(), l.Addr())
+	if _, err := ch.Finish(readDatagram(t, pc)); err != rudp.ErrHelloVerify {
+		t.Fatal("Listener error, hello without cookie should be challenged:", err)
+	}
+
+	pc.WriteTo(ch.Hello(), l.Addr())
+	serverHello := readDatagram(t, pc)
+	keys, err := ch.Finish(serverHello)
+	if err != nil {
+		t.Fatal("Listener error, handshake should complete:", err)
+	}
+	// the server hello got lost, the same one is sent again
+	pc.WriteTo(ch.Hello(), l.Addr())
+	if d := readDatagram(t, pc); !bytes.Equal(d, serverHello) {
+		t.Error("Listener error, repeated hello should get the same server hello.")
+	}
+
+	c := rudp.NewConn(pc, l.Addr(), rudp.CreateWithDuration(time.Millisecond, time.Second,
+		512, rudp.Options{SendKey: keys.Send, RecvKey: keys.Recv}), nil)
+	defer c.Close()
+	go c.ReadLoop()
+	c.Send([]byte{42})
+	s := acceptTimeout(l, 2*time.Second)
+	if s == nil {
+		t.Fatal("Listener::Accept error, peer should be accepted after the handshake.")
+	}
+	if msg := waitRecv(t, s); len(msg) != 1 || msg[0] != 42 {
+		t.Errorf("Listener error, peer sent %v.", msg)
+	}
+}
@@ -0,0 +1,86 @@
+package rudp
+
+import (
+	"errors"
+	"sync"
+)
+
+const (
+	messageMinBuffer = 64
+	// buffers come in power of two classes from messageMinBuffer up to the
+	// first one holding MaxPackageSize
+	messageClasses = 10
+	// messages kept by a RUDP object when Options.MessagePoolSize is 0
+	defaultMessagePoolSize = 128
+)
+
+// sharedMessagePools back every RUDP object created with
+// Options.SharedMessagePool, one pool per buffer class
+var sharedMessagePools [messageClasses]sync.Pool
+
+// ErrCorrupt is returned by RecvMessage for a corrupted connection
+var ErrCorrupt = errors.New("rudp: corrupted connection")
+
+// Message is a received message handed out by RecvMessage
+type Message message
+
+// Bytes returns the payload, it is valid until Release
+func (m *Message) Bytes() []byte {
+	return m.buffer[:m.sz]
+}
+
+// Release hands the message back for reuse, it may be called from any
+// goroutine. Like RUDPPackage.Release it is optional.
+func (m *Message) Release() {
+	m.next = nil
+	sharedMessagePools[messageClass(len(m.buffer))].Put((*message)(m))
+}
+
+// messageClass returns the smallest class whose buffers hold sz bytes
+func messageClass(sz int) int {
+	c := 0
+	for size := messageMinBuffer; size < sz; size <<= 1 {
+		c++
+	}
+	return c
+}
+
+// messagePool keeps freed messages in free lists per buffer class, or in
+// the shared pools. At most limit messages are kept, the rest are left to
+// the garbage collector.
+type messagePool struct {
+	free   [messageClasses]*message
+	count  int
+	limit  int
+	shared bool
+}
+
+func (p *messagePool) get(sz int) *message {
+	c := messageClass(sz)
+	if p.shared {
+		if m, ok := sharedMessagePools[c].Get().(*message); ok {
+			return m
+		}
+	} else if m := p.free[c]; m != nil {
+		p.free[c] = m.next
+		p.count--
+		m.next = nil
+		return m
+	}
+	return &message{buffer: make([]byte, messageMinBuffer<<uint(c))}
+}
+
+func (p *messagePool) put(m *message) {
+	c := messageClass(len(m.buffer))
+	m.next = nil
+	if p.shared {
+		sharedMessagePools[c].Put(m)
+		return
+	}
+	if p.count >= p.limit {
+		return
+	}
+	m.next = p.free[c]
+	p.free[c] = m
+	p.count++
+}
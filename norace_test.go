@@ -0,0 +1,5 @@
+//go:build !race
+
+package rudp_test
+
+const raceEnabled = false
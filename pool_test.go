@@ -0,0 +1,119 @@
+package rudp_test
+
+import (
+	"encoding/binary"
+	"fmt"
+	"testing"
+
+	"github.com/bennychen/rudp"
+)
+
+// exchange delivers the packages of from to to and releases them
+func exchange(from, to *rudp.RUDP) {
+	p := from.Update(nil, 0, 1)
+	for q := p; q != nil; q = q.Next {
+		to.Update(q.Buffer, q.Size, 0)
+	}
+	p.Release()
+}
+
+func TestPackageRelease(t *testing.T) {
+	fmt.Println("=======================TestPackageRelease======================")
+
+	opts := rudp.Options{Compression: true, Checksum: true, FECGroupSize: 3}
+	A := rudp.CreateWithOptions(1, 50, 512, opts)
+	B := rudp.CreateWithOptions(1, 50, 512, opts)
+
+	var nilPackage *rudp.RUDPPackage
+	nilPackage.Release()
+
+	const messages = 300
+	buf := make([]byte, 600)
+	tmp := make([]byte, rudp.MaxPackageSize)
+	next := 0
+	for i := 0; i < messages; i++ {
+		// sizes vary so recycled buffers are both grown and shrunk
+		sz := 4 + i*7%len(buf[4:])
+		binary.BigEndian.PutUint32(buf, uint32(i))
+		for j := 4; j < sz; j++ {
+			buf[j] = byte(i + j)
+		}
+		A.Send(buf, sz)
+		exchange(A, B)
+		exchange(B, A)
+		for n := B.Recv(tmp); n != 0; n = B.Recv(tmp) {
+			if n < 0 {
+				t.Fatal("RUDP::Recv error, connection should not be corrupt.")
+			}
+			if int(binary.BigEndian.Uint32(tmp)) != next {
+				t.Fatalf("RUDP::Recv error, expect message %d.", next)
+			}
+			for j := 4; j < n; j++ {
+				if tmp[j] != byte(next+j) {
+					t.Fatalf("RUDP::Recv error, message %d is damaged.", next)
+				}
+			}
+			next++
+		}
+	}
+	if next != messages {
+		t.Errorf("RUDP::Recv error, received %d of %d messages.", next, messages)
+	}
+}
+
+func TestMessagePoolLimit(t *testing.T) {
+	fmt.Println("=======================TestMessagePoolLimit======================")
+
+	U := rudp.CreateWithOptions(1, 5, 512, rudp.Options{MessagePoolSize: 16})
+	burst := make([]byte, 1000)
+	for i := 0; i < 100; i++ {
+		U.Send(burst, len(burst))
+	}
+	U.Update(nil, 0, 1).Release()
+	U.Update(nil, 0, 10).Release()
+	U.Update(nil, 0, 10).Release() // the whole burst expires
+	if n := U.DebugGetPoolSize(); n != 16 {
+		t.Errorf("Pool size should be 16, not %d.", n)
+	}
+
+	// small messages do not take the large buffers of the burst
+	U.Send([]byte{1}, 1)
+	if n := U.DebugGetPoolSize(); n != 16 {
+		t.Errorf("Pool size should stay 16, not %d.", n)
+	}
+	U.Send(burst, len(burst))
+	if n := U.DebugGetPoolSize(); n != 15 {
+		t.Errorf("Pool size should be 15, not %d.", n)
+	}
+
+	U = rudp.CreateWithOptions(1, 5, 512, rudp.Options{MessagePoolSize: -1})
+	U.Send(burst, len(burst))
+	U.Update(nil, 0, 1).Release()
+	U.Update(nil, 0, 10).Release()
+	U.Update(nil, 0, 10).Release()
+	if n := U.DebugGetPoolSize(); n != 0 {
+		t.Errorf("Pool size should be 0 when disabled, not %d.", n)
+	}
+}
+
+func TestSharedMessagePool(t *testing.T) {
+	fmt.Println("=======================TestSharedMessagePool======================")
+
+	opts := rudp.Options{SharedMessagePool: true}
+	A := rudp.CreateWithOptions(1, 5, 512, opts)
+	B := rudp.CreateWithOptions(1, 5, 512, opts)
+	tmp := make([]byte, rudp.MaxPackageSize)
+	for i := 0; i < 50; i++ {
+		msg := []byte{byte(i), 1, 2, 3}
+		A.Send(msg, len(msg))
+		exchange(A, B)
+		exchange(B, A)
+		n := B.Recv(tmp)
+		if n != len(msg) || tmp[0] != byte(i) {
+			t.Fatalf("RUDP::Recv error, should receive message %d.", i)
+		}
+	}
+	if A.DebugGetPoolSize() != 0 || B.DebugGetPoolSize() != 0 {
+		t.Error("Pool size should be 0 when the shared pool is used.")
+	}
+}
@@ -0,0 +1,6 @@
+//go:build race
+
+package rudp_test
+
+// the race detector drops pooled objects at random
+const raceEnabled = true
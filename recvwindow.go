@@ -0,0 +1,68 @@
+package rudp
+
+const (
+	// the default send and receive window of 32-bit ids, 16-bit ids use
+	// half of the id space
+	defaultWindow32 = 1 << 17
+	// the receive ring never grows beyond twice this many slots
+	maxRecvWindow = 1 << 20
+)
+
+func (u *RUDP) insertMessageToRecvQueue(id uint32, buffer []byte, sz int) {
+	distance := u.compareID(id, u.currentRecvIDMin)
+	if distance < 0 {
+		// received before
+		return
+	}
+	// a single package grows the ring by one step at most, so an id far
+	// ahead costs no more memory than a message just beyond the ring
+	if distance >= u.recvWindowSize || !u.recvWindow.grow(distance) {
+		if !u.recvAhead || u.compareID(id, u.recvAheadID) > 0 {
+			u.recvAheadID = id
+			u.recvAhead = true
+		}
+		return
+	}
+	if u.recvWindow.get(id) != nil {
+		// Duplicated message
+		return
+	}
+	if u.recvWindow.count == 0 || u.compareID(id, u.currentRecvIDMax) > 0 {
+		u.currentRecvIDMax = id
+	}
+	m := u.createMessage(buffer, sz)
+	m.id = id
+	u.recvWindow.put(m)
+}
+
+// consumer requests missing packets
+func (u *RUDP) requestMissing(tmp *tmpBuffer) {
+	next := u.currentRecvIDMin
+	if u.recvWindow.count > 0 {
+		n := u.compareID(u.currentRecvIDMax, u.currentRecvIDMin)
+		u.recvWindow.gaps(u.currentRecvIDMin, n, func(offset int) {
+			u.packRequest(tmp, (u.currentRecvIDMin+uint32(offset))&u.idMask, TypeRequest)
+		})
+		next = u.nextID(u.currentRecvIDMax)
+	}
+	if !u.recvAhead {
+		return
+	}
+	if u.compareID(u.recvAheadID, next) < 0 {
+		u.recvAhead = false
+		return
+	}
+	// dropped messages are requested as far as the ring can grow to
+	// hold them, the rest once those arrived
+	limit := 2 * len(u.recvWindow.slots)
+	if limit > u.recvWindowSize {
+		limit = u.recvWindowSize
+	}
+	last := u.recvAheadID
+	if u.compareID(last, u.currentRecvIDMin) >= limit {
+		last = (u.currentRecvIDMin + uint32(limit) - 1) & u.idMask
+	}
+	for id := next; u.compareID(id, last) <= 0; id = u.nextID(id) {
+		u.packRequest(tmp, id, TypeRequest)
+	}
+}
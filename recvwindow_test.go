@@ -0,0 +1,157 @@
+package rudp_test
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math/rand"
+	"runtime"
+	"testing"
+
+	"github.com/bennychen/rudp"
+)
+
+// messagePackage builds a package holding one 4 byte message with a 16-bit id
+func messagePackage(buf []byte, id uint16) []byte {
+	buf[0] = byte(rudp.TypeNormal + 4)
+	binary.BigEndian.PutUint16(buf[1:], id)
+	binary.BigEndian.PutUint32(buf[3:], uint32(id))
+	return buf[:7]
+}
+
+func TestRecvWindow(t *testing.T) {
+	fmt.Println("=======================TestRecvWindow======================")
+
+	idx = 0
+	U := rudp.CreateWithOptions(1, 5, 512, rudp.Options{RecvWindow: 200})
+	buf := make([]byte, 7)
+
+	// ids 1 and 3 are missing, 150 is beyond what one package may grow
+	// the ring to and 200 beyond the receive window, both are dropped
+	for _, id := range []uint16{4, 2, 0, 150, 5, 2, 200} {
+		p := messagePackage(buf, id)
+		U.Update(p, len(p), 0)
+	}
+
+	requests := func() string {
+		var ids []uint16
+		p := U.Update(nil, 0, 1)
+		for q := p; q != nil; q = q.Next {
+			for i := 0; i+3 <= q.Size; i += 3 {
+				if q.Buffer[i] != rudp.TypeRequest {
+					t.Fatal("RUDP::Update error, should only send requests.")
+				}
+				ids = append(ids, binary.BigEndian.Uint16(q.Buffer[i+1:]))
+			}
+		}
+		dump(p)
+		return fmt.Sprint(ids)
+	}
+	idRange := func(ids []uint16, from, to uint16) []uint16 {
+		for id := from; id <= to; id++ {
+			ids = append(ids, id)
+		}
+		return ids
+	}
+
+	// dropped messages are requested again up to the end of the window
+	if got := requests(); got != fmt.Sprint(idRange([]uint16{1, 3}, 6, 199)) {
+		t.Errorf("RUDP::Update error, should request ids 1, 3 and 6 to 199, not %v.", got)
+	}
+	// the ring grows for the requested message
+	p := messagePackage(buf, 150)
+	U.Update(p, len(p), 0)
+	if got := requests(); got != fmt.Sprint(idRange(idRange([]uint16{1, 3}, 6, 149), 151, 199)) {
+		t.Errorf("RUDP::Update error, message 150 should be stored, requests %v.", got)
+	}
+
+	if dumpRecv(U) != "RECV 0 0 0 0\n" {
+		t.Error("RUDP::Recv error, only message 0 should be ready.")
+	}
+	for _, id := range []uint16{3, 1} {
+		p := messagePackage(buf, id)
+		U.Update(p, len(p), 0)
+	}
+	tmp := make([]byte, rudp.MaxPackageSize)
+	for id := uint32(1); id <= 5; id++ {
+		n := U.Recv(tmp)
+		if n != 4 || binary.BigEndian.Uint32(tmp) != id {
+			t.Errorf("RUDP::Recv error, should receive message %d.", id)
+		}
+	}
+	if U.Recv(tmp) != 0 {
+		t.Error("RUDP::Recv error, message 6 has not arrived yet.")
+	}
+}
+
+func TestRecvWindowBurst(t *testing.T) {
+	fmt.Println("=======================TestRecvWindowBurst======================")
+
+	// default windows of 32-bit ids take more than 0x8000 messages at once
+	opts := rudp.Options{IDWidth: rudp.IDWidth32}
+	A := rudp.CreateWithOptions(1, 1000, 512, opts)
+	B := rudp.CreateWithOptions(1, 1000, 512, opts)
+	const messages = 40000
+	msg := make([]byte, 4)
+	for i := 0; i < messages; i++ {
+		binary.BigEndian.PutUint32(msg, uint32(i))
+		A.Send(msg, len(msg))
+	}
+	tmp := make([]byte, rudp.MaxPackageSize)
+	next := 0
+	for tick := 0; tick < 10 && next < messages; tick++ {
+		exchange(A, B)
+		exchange(B, A)
+		for n := B.Recv(tmp); n != 0; n = B.Recv(tmp) {
+			if n != 4 || int(binary.BigEndian.Uint32(tmp)) != next {
+				t.Fatalf("RUDP::Recv error, expect message %d.", next)
+			}
+			next++
+		}
+	}
+	if next != messages {
+		t.Errorf("RUDP::Recv error, received %d of %d messages.", next, messages)
+	}
+
+	// an id far ahead inside a large window only grows the ring by one step
+	U := rudp.CreateWithOptions(1, 1000, 512,
+		rudp.Options{IDWidth: rudp.IDWidth32, RecvWindow: 1 << 20})
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	p := []byte{rudp.TypeNormal + 1, 0, 0x0f, 0, 0, 1}
+	U.Update(p, len(p), 0)
+	runtime.ReadMemStats(&after)
+	if grown := after.TotalAlloc - before.TotalAlloc; grown > 64<<10 {
+		t.Errorf("RUDP::Update error, far ahead id allocated %d bytes.", grown)
+	}
+}
+
+func benchmarkReorder(b *testing.B, outstanding int) {
+	u := rudp.Create(1, 5, 512)
+	// the first message arrives last, so all others stay in the window
+	order := rand.New(rand.NewSource(1)).Perm(outstanding - 1)
+	buf := make([]byte, 7)
+	tmp := make([]byte, rudp.MaxPackageSize)
+	base := 0
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, k := range order {
+			p := messagePackage(buf, uint16(base+k+1))
+			u.Update(p, len(p), 0)
+		}
+		u.Update(nil, 0, 1).Release()
+		p := messagePackage(buf, uint16(base))
+		u.Update(p, len(p), 0)
+		for n := u.Recv(tmp); n > 0; n = u.Recv(tmp) {
+		}
+		base += outstanding
+	}
+}
+
+func BenchmarkReorder(b *testing.B) {
+	for _, n := range []int{16, 256, 4096} {
+		b.Run(fmt.Sprint(n), func(b *testing.B) {
+			benchmarkReorder(b, n)
+		})
+	}
+}
@@ -0,0 +1,188 @@
+package rudp
+
+// arithmetic over GF(256) with the polynomial x^8 + x^4 + x^3 + x^2 + 1,
+// used to compute and solve Reed-Solomon parity
+
+var gfExp [510]byte
+var gfLog [256]byte
+
+func init() {
+	x := 1
+	for i := 0; i < 255; i++ {
+		gfExp[i] = byte(x)
+		gfLog[x] = byte(i)
+		x <<= 1
+		if x&0x100 != 0 {
+			x ^= 0x11d
+		}
+	}
+	for i := 255; i < len(gfExp); i++ {
+		gfExp[i] = gfExp[i-255]
+	}
+}
+
+func gfMul(a byte, b byte) byte {
+	if a == 0 || b == 0 {
+		return 0
+	}
+	return gfExp[int(gfLog[a])+int(gfLog[b])]
+}
+
+// gfInv returns the multiplicative inverse of a, a must not be 0
+func gfInv(a byte) byte {
+	return gfExp[255-int(gfLog[a])]
+}
+
+// gfMulAdd adds c * src to dst, dst must be at least as long as src
+func gfMulAdd(dst []byte, src []byte, c byte) {
+	switch c {
+	case 0:
+	case 1:
+		for i, b := range src {
+			dst[i] ^= b
+		}
+	default:
+		for i, b := range src {
+			dst[i] ^= gfMul(c, b)
+		}
+	}
+}
+
+// gfInvertMatrix inverts a square matrix with Gauss-Jordan elimination,
+// false is returned if the matrix is singular
+func gfInvertMatrix(m [][]byte) ([][]byte, bool) {
+	n := len(m)
+	a := make([][]byte, n)
+	for i := range m {
+		a[i] = make([]byte, 2*n)
+		copy(a[i], m[i])
+		a[i][n+i] = 1
+	}
+	for col := 0; col < n; col++ {
+		pivot := col
+		for pivot < n && a[pivot][col] == 0 {
+			pivot++
+		}
+		if pivot == n {
+			return nil, false
+		}
+		a[col], a[pivot] = a[pivot], a[col]
+		inv := gfInv(a[col][col])
+		for j := range a[col] {
+			a[col][j] = gfMul(a[col][j], inv)
+		}
+		for r := 0; r < n; r++ {
+			if r != col {
+				gfMulAdd(a[r], a[col], a[r][col])
+			}
+		}
+	}
+	for i := range a {
+		a[i] = a[i][n:]
+	}
+	return a, true
+}
+
+// fecCodec is a systematic erasure code, data shards go out unchanged and
+// each parity shard is a linear combination of the data shards over GF(256).
+// Any dataShards shards out of a group are enough to rebuild the data.
+type fecCodec struct {
+	dataShards   int
+	parityShards int
+	matrix       [][]byte // parityShards rows of dataShards coefficients
+}
+
+// newXORCodec returns a codec with one parity shard being the XOR of all
+// data shards
+func newXORCodec(dataShards int) *fecCodec {
+	row := make([]byte, dataShards)
+	for i := range row {
+		row[i] = 1
+	}
+	return &fecCodec{dataShards, 1, [][]byte{row}}
+}
+
+// newReedSolomonCodec returns a codec built on a Cauchy matrix, so that any
+// dataShards rows of the identity stacked on it are invertible,
+// dataShards + parityShards must not exceed 256
+func newReedSolomonCodec(dataShards int, parityShards int) *fecCodec {
+	matrix := make([][]byte, parityShards)
+	for j := range matrix {
+		matrix[j] = make([]byte, dataShards)
+		for i := range matrix[j] {
+			matrix[j][i] = gfInv(byte(dataShards+j) ^ byte(i))
+		}
+	}
+	return &fecCodec{dataShards, parityShards, matrix}
+}
+
+// encode computes the parity shards of a complete group, shorter data
+// shards are treated as zero padded, the shard size is returned
+func (c *fecCodec) encode(shards [][]byte, parity [][]byte) int {
+	size := 0
+	for _, s := range shards {
+		if len(s) > size {
+			size = len(s)
+		}
+	}
+	for j := range parity {
+		parity[j] = zeroShard(parity[j], size)
+		for i, s := range shards {
+			gfMulAdd(parity[j], s, c.matrix[j][i])
+		}
+	}
+	return size
+}
+
+// reconstruct rebuilds the missing data shards, shards holds the data
+// shards followed by the parity shards and present marks received ones
+func (c *fecCodec) reconstruct(shards [][]byte, present []bool) bool {
+	k := c.dataShards
+	rows := make([][]byte, 0, k)
+	inputs := make([][]byte, 0, k)
+	size := 0
+	for i := 0; i < len(shards) && len(rows) < k; i++ {
+		if !present[i] {
+			continue
+		}
+		row := make([]byte, k)
+		if i < k {
+			row[i] = 1
+		} else {
+			copy(row, c.matrix[i-k])
+		}
+		rows = append(rows, row)
+		inputs = append(inputs, shards[i])
+		if len(shards[i]) > size {
+			size = len(shards[i])
+		}
+	}
+	if len(rows) < k {
+		return false
+	}
+	inv, ok := gfInvertMatrix(rows)
+	if !ok {
+		return false
+	}
+	for m := 0; m < k; m++ {
+		if present[m] {
+			continue
+		}
+		shards[m] = zeroShard(shards[m], size)
+		for r := 0; r < k; r++ {
+			gfMulAdd(shards[m], inputs[r], inv[m][r])
+		}
+	}
+	return true
+}
+
+func zeroShard(shard []byte, size int) []byte {
+	if cap(shard) < size {
+		return make([]byte, size)
+	}
+	shard = shard[:size]
+	for i := range shard {
+		shard[i] = 0
+	}
+	return shard
+}
@@ -0,0 +1,12 @@
+//go:build !linux
+
+package rudp
+
+import "syscall"
+
+// SO_REUSEPORT does not spread flows elsewhere, listeners use one shard
+const reusePortSupported = false
+
+func reusePort(network, address string, c syscall.RawConn) error {
+	return nil
+}
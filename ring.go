@@ -0,0 +1,104 @@
+package rudp
+
+import "math/bits"
+
+const messageRingMinSize = 64
+
+// messageRing holds messages indexed by id, a message with id lives in slot
+// id&(len(slots)-1). The ids stored at the same time must lie within
+// len(slots) of each other, so a slot never holds two messages. The ring
+// grows by doubling through reserve, or one step at a time through grow.
+type messageRing struct {
+	slots   []*message
+	present []uint64 // bit i is set when slots[i] holds a message
+	count   int
+}
+
+func (w *messageRing) get(id uint32) *message {
+	if len(w.slots) == 0 {
+		return nil
+	}
+	return w.slots[id&uint32(len(w.slots)-1)]
+}
+
+func (w *messageRing) put(m *message) {
+	i := m.id & uint32(len(w.slots)-1)
+	w.slots[i] = m
+	w.present[i>>6] |= 1 << (i & 63)
+	w.count++
+}
+
+func (w *messageRing) take(id uint32) *message {
+	m := w.get(id)
+	if m == nil || m.id != id {
+		return nil
+	}
+	i := id & uint32(len(w.slots)-1)
+	w.slots[i] = nil
+	w.present[i>>6] &^= 1 << (i & 63)
+	w.count--
+	return m
+}
+
+// reserve makes room for ids up to distance ahead of the lowest stored one
+func (w *messageRing) reserve(distance int) {
+	n := len(w.slots)
+	if distance < n {
+		return
+	}
+	if n == 0 {
+		n = messageRingMinSize
+	}
+	for n <= distance {
+		n *= 2
+	}
+	w.resize(n)
+}
+
+// grow is reserve doubling the ring once at most, false is returned if
+// distance is still beyond it
+func (w *messageRing) grow(distance int) bool {
+	if distance < len(w.slots) {
+		return true
+	}
+	n := 2 * len(w.slots)
+	if n == 0 {
+		n = messageRingMinSize
+	}
+	w.resize(n)
+	return distance < n
+}
+
+func (w *messageRing) resize(n int) {
+	slots := w.slots
+	w.slots = make([]*message, n)
+	w.present = make([]uint64, n/64)
+	w.count = 0
+	for _, m := range slots {
+		if m != nil {
+			w.put(m)
+		}
+	}
+}
+
+// gaps calls f with the offset of every empty slot among the n slots
+// following the one of id, runs of stored messages are skipped 64 at a time
+func (w *messageRing) gaps(id uint32, n int, f func(offset int)) {
+	mask := uint32(len(w.slots) - 1)
+	for off := 0; off < n; {
+		i := (id + uint32(off)) & mask
+		word := w.present[i>>6] >> (i & 63)
+		avail := 64 - int(i&63)
+		skip := bits.TrailingZeros64(^word)
+		if skip >= avail {
+			off += avail
+			continue
+		}
+		off += skip
+		if off >= n {
+			return
+		}
+		f(off)
+		off++
+	}
+}
@@ -3,6 +3,9 @@ package rudp
 import (
 	"encoding/binary"
 	"fmt"
+	"net"
+	"sync"
+	"time"
 )
 
 // the algorithm is based on http://blog.codingnow.com/2016/03/reliable_udp.html
@@ -18,26 +21,137 @@ const (
 	TypeNormal           // provider sends normal message to consumer
 )
 
+// message id widths on the wire, in bytes
+const (
+	IDWidth16 = 2 // default, compatible with the C/C#/TypeScript ports
+	IDWidth32 = 4 // for links that may hold more than 0x8000 messages in flight
+)
+
+// Options holds optional protocol settings for CreateWithOptions.
+// The zero value gives the original wire format.
+type Options struct {
+	IDWidth int // IDWidth16 (default) or IDWidth32, both peers must agree
+
+	// MaxMessageSize limits the size of sent and received messages, a
+	// larger message from the peer corrupts the connection. 0 or anything
+	// above MaxPackageSize means MaxPackageSize
+	MaxMessageSize int
+
+	// SendWindow limits how far a new message id may run ahead of the
+	// oldest message still held in history. 0 means half of the id space
+	// for 16-bit ids and 131072 for 32-bit ids, anything above half of the
+	// id space means half of it. History only expires
+	// every ExpiredTime ticks, so at most SendWindow messages are sent per
+	// ExpiredTime
+	SendWindow int
+
+	// RecvWindow limits how far ahead of the next expected message id a
+	// received message may be, it should not be below the SendWindow of
+	// the peer. Messages beyond it are dropped and requested again once
+	// the window gets there. 0 means the default SendWindow, it is at most
+	// 1048576 and half of the id space
+	RecvWindow int
+
+	// MessagePoolSize limits how many freed messages are kept for reuse,
+	// 0 means 128 and a negative value disables the pool
+	MessagePoolSize int
+
+	// SharedMessagePool keeps freed messages in a sync.Pool shared by all
+	// RUDP objects instead, which the runtime shrinks after spikes
+	SharedMessagePool bool
+
+	// FECGroupSize is the number of data packages in a forward error
+	// correction group, 0 disables it, both peers must agree on all FEC options
+	FECGroupSize    int
+	FECMode         int // FECXOR (default) or FECReedSolomon
+	FECParityShards int // parity packages per group for FECReedSolomon
+
+	// Compression DEFLATEs every outgoing package that gets smaller by it,
+	// both peers must agree
+	Compression bool
+
+	// Checksum appends a CRC32C to every package, received packages failing
+	// it are dropped, both peers must agree
+	Checksum bool
+
+	// EncryptionKey turns on AES-GCM authenticated encryption of every
+	// package, it must be 16, 24 or 32 bytes long and shared by both peers
+	EncryptionKey []byte
+
+	// SendKey and RecvKey are per direction keys replacing EncryptionKey,
+	// usually the SessionKeys of a handshake
+	SendKey []byte
+	RecvKey []byte
+
+	// SessionID is mixed into the encryption keys. Peers sharing an
+	// EncryptionKey should agree on a new one for every session, packages
+	// recorded in another session are then rejected instead of delivered
+	SessionID []byte
+
+	// Salt is the 16 bytes salt sealed packages are sent with, nil picks a
+	// random one. PeerSalt is the Salt of the peer when the peers exchange
+	// them at setup, packages with any other salt are then rejected
+	// without deriving a key. nil accepts the salt of the first authentic
+	// package, see crypto.go
+	Salt     []byte
+	PeerSalt []byte
+
+	// Tick is the length of one tick for CreateWithDuration and UpdateAt,
+	// 0 means a millisecond
+	Tick time.Duration
+}
+
+// Stats holds counters of what happened inside a RUDP object
+type Stats struct {
+	WindowStalls     int // send ticks on which the send window held messages back
+	TailProbes       int // newest messages sent again while the window stalled
+	FECRecovered     int // lost packages rebuilt by forward error correction
+	Retransmitted    int // messages sent again on request of the other side
+	Rejected         int // received packages dropped for failing authentication
+	Replayed         int // authentic packages dropped as replays
+	ChecksumFailures int // received packages dropped for a wrong checksum
+}
+
 type RUDP struct {
 	SendDelay   int // after how long we should send messages
 	ExpiredTime int // after how long messages in history should be cleared
 
-	mtu         int // maximum transmission unit size, recommended value 512
-	sendQueue   messageQueue
-	recvQueue   messageQueue
-	sendHistroy messageQueue // keep message history in case we need to resend
-	messagePool *message
+	mtu            int // maximum transmission unit size, recommended value 512
+	maxMsgSize     int
+	idWidth        int // bytes of message id on the wire
+	idMask         uint32
+	idHalf         uint32 // half of the id space, used to order wrapped ids
+	sendWindow     uint32
+	sendQueue      messageQueue
+	recvWindow     messageRing
+	recvWindowSize int
+	sendHistroy    messageQueue // keep message history in case we need to resend
+	sendIndex      messageRing  // sendHistroy indexed by id
+	messagePool    messagePool
 
 	sendPackage *RUDPPackage // returned by RUDP::Update
-	sendAgain   []uint16     // package ids to send again
+	fecEncoder  *fecEncoder  // nil when forward error correction is off
+	fecDecoder  *fecDecoder
+	compressor  *compressor // nil when compression is off
+	checksum    bool
+	sealer      *sealer   // nil when encryption is off
+	sendAgain   []uint32  // package ids to send again
+	out         tmpBuffer // reused by genOutPackage
+
+	tick       time.Duration
+	lastUpdate time.Time // tick boundary reached by UpdateAt
 
 	corrupt          bool
 	currentTick      int
 	lastSendTick     int
 	lastExpiredTick  int
-	currentSendID    uint16
-	currentRecvIDMin uint16
-	currentRecvIDMax uint16
+	currentSendID    uint32
+	currentRecvIDMin uint32
+	currentRecvIDMax uint32
+	recvAhead        bool   // a message beyond the receive window was dropped
+	recvAheadID      uint32 // the highest id of those, requested again later
+
+	stats Stats
 }
 
 type RUDPPackage struct {
@@ -46,30 +160,161 @@ type RUDPPackage struct {
 	Size   int
 }
 
+// packages below this size share buffers of this size in the pool
+const packageBufferSize = 512
+
+var packagePool = sync.Pool{
+	New: func() interface{} {
+		return &RUDPPackage{}
+	},
+}
+
+// takePackage returns a package from the pool with a buffer of sz bytes
+func takePackage(sz int) *RUDPPackage {
+	p := packagePool.Get().(*RUDPPackage)
+	if cap(p.Buffer) < sz {
+		if sz < packageBufferSize {
+			p.Buffer = make([]byte, sz, packageBufferSize)
+		} else {
+			p.Buffer = make([]byte, sz)
+		}
+	}
+	p.Buffer = p.Buffer[:sz]
+	p.Size = sz
+	p.Next = nil
+	return p
+}
+
+// Release returns the package and all packages after it to the pool, none
+// of them may be used afterwards. Packages that are never released are
+// simply garbage collected.
+func (p *RUDPPackage) Release() {
+	for p != nil {
+		next := p.Next
+		p.Next = nil
+		packagePool.Put(p)
+		p = next
+	}
+}
+
 func Create(sendDelay int, expiredTime int, mtu int) *RUDP {
+	return CreateWithOptions(sendDelay, expiredTime, mtu, Options{})
+}
+
+// CreateWithOptions creates a RUDP object with optional protocol settings
+func CreateWithOptions(sendDelay int, expiredTime int, mtu int, opts Options) *RUDP {
 	u := &RUDP{}
 	u.mtu = mtu
 	if u.mtu < 128 {
 		u.mtu = 128
 	}
+	u.maxMsgSize = MaxPackageSize
+	if opts.MaxMessageSize > 0 && opts.MaxMessageSize < MaxPackageSize {
+		u.maxMsgSize = opts.MaxMessageSize
+	}
+	u.idWidth = opts.IDWidth
+	if u.idWidth != IDWidth32 {
+		u.idWidth = IDWidth16
+	}
+	u.idMask = uint32(1)<<(uint(u.idWidth)*8) - 1
+	u.idHalf = u.idMask/2 + 1
+	window := u.idHalf
+	if window > defaultWindow32 {
+		window = defaultWindow32
+	}
+	u.sendWindow = window
+	if opts.SendWindow > 0 {
+		u.sendWindow = u.idHalf
+		if uint64(opts.SendWindow) < uint64(u.idHalf) {
+			u.sendWindow = uint32(opts.SendWindow)
+		}
+	}
+	u.recvWindowSize = int(window)
+	if opts.RecvWindow > 0 {
+		u.recvWindowSize = opts.RecvWindow
+	}
+	if u.recvWindowSize > maxRecvWindow {
+		u.recvWindowSize = maxRecvWindow
+	}
+	if uint64(u.recvWindowSize) > uint64(u.idHalf) {
+		u.recvWindowSize = int(u.idHalf)
+	}
+	if codec := newFECCodec(opts); codec != nil {
+		u.fecEncoder = newFECEncoder(codec)
+		u.fecDecoder = newFECDecoder(codec)
+		// leave room for the FEC header and the parity length
+		u.mtu -= fecHeaderSize + fecShardHeader
+	}
+	if opts.Compression {
+		u.compressor = newCompressor()
+		u.mtu -= compressHeaderSize
+	}
+	if opts.Checksum {
+		u.checksum = true
+		u.mtu -= checksumSize
+	}
+	if opts.SendKey != nil || opts.RecvKey != nil {
+		u.sealer = newSealer(opts.SendKey, opts.RecvKey, opts)
+		u.mtu -= sealOverhead
+	} else if opts.EncryptionKey != nil {
+		u.sealer = newSealer(opts.EncryptionKey, opts.EncryptionKey, opts)
+		u.mtu -= sealOverhead
+	}
+	u.tick = opts.Tick
+	if u.tick <= 0 {
+		u.tick = defaultTick
+	}
 	u.SendDelay = sendDelay
 	u.ExpiredTime = expiredTime
-	u.sendAgain = make([]uint16, 0)
+	u.messagePool.limit = defaultMessagePoolSize
+	if opts.MessagePoolSize != 0 {
+		u.messagePool.limit = opts.MessagePoolSize
+	}
+	u.messagePool.shared = opts.SharedMessagePool
+	u.sendAgain = make([]uint32, 0, 8)
+	u.out.buffer = make([]byte, u.mtu)
 	return u
 }
 
 // Send sends a new package out
 func (u *RUDP) Send(buffer []byte, sz int) {
-	if sz > MaxPackageSize {
+	if sz > u.maxMsgSize {
 		fmt.Println("package size is too large.")
 		return
 	}
 	if sz > len(buffer) {
 		sz = len(buffer)
 	}
-	m := u.createMessage(buffer, sz)
+	u.queueMessage(u.createMessage(buffer, sz))
+}
+
+// SendV sends the parts joined together as one message, they are copied
+// straight into the message buffer
+func (u *RUDP) SendV(parts [][]byte) {
+	sz := 0
+	for _, part := range parts {
+		sz += len(part)
+	}
+	if sz > u.maxMsgSize {
+		fmt.Println("package size is too large.")
+		return
+	}
+	m := u.createMessage(nil, sz)
+	n := 0
+	for _, part := range parts {
+		n += copy(m.buffer[n:], part)
+	}
+	u.queueMessage(m)
+}
+
+// SendBuffers works like SendV
+func (u *RUDP) SendBuffers(buffers net.Buffers) {
+	u.SendV(buffers)
+}
+
+func (u *RUDP) queueMessage(m *message) {
 	m.id = u.currentSendID
-	u.currentSendID++
+	u.currentSendID = u.nextID(u.currentSendID)
 	m.tick = u.currentTick
 	u.sendQueue.push(m)
 }
@@ -77,20 +322,60 @@ func (u *RUDP) Send(buffer []byte, sz int) {
 // Recv receives message and returns the size of the new message
 // 0 = no new message
 // -1 = corrupted connection
+// -2 = buffer is shorter than the message, it stays queued until a large
+// enough buffer is passed, NextSize returns its size
 func (u *RUDP) Recv(buffer []byte) int {
 	if u.corrupt {
 		u.corrupt = false
 		return -1
 	}
-	m := u.recvQueue.pop(u.currentRecvIDMin)
-	if m == nil {
+	m := u.recvWindow.get(u.currentRecvIDMin)
+	if m == nil || m.id != u.currentRecvIDMin {
 		return 0
 	}
-	u.currentRecvIDMin++
-	if m.sz > 0 {
-		copy(buffer, m.buffer)
+	if m.sz > len(buffer) {
+		return -2
+	}
+	u.recvWindow.take(m.id)
+	u.currentRecvIDMin = u.nextID(u.currentRecvIDMin)
+	sz := m.sz
+	if sz > 0 {
+		copy(buffer, m.buffer[:sz])
 	}
 	u.deleteMessage(m)
+	return sz
+}
+
+// RecvMessage receives the next message without copying it, nil is
+// returned when there is none and ErrCorrupt for a corrupted connection.
+// The caller owns the message until it calls Release.
+func (u *RUDP) RecvMessage() (*Message, error) {
+	if u.corrupt {
+		u.corrupt = false
+		return nil, ErrCorrupt
+	}
+	m := u.recvWindow.take(u.currentRecvIDMin)
+	if m == nil {
+		return nil, nil
+	}
+	u.currentRecvIDMin = u.nextID(u.currentRecvIDMin)
+	if m.sz < 0 {
+		u.deleteMessage(m)
+		return nil, ErrCorrupt
+	}
+	return (*Message)(m), nil
+}
+
+// NextSize returns what the next Recv would return without receiving,
+// given a buffer large enough for the message
+func (u *RUDP) NextSize() int {
+	if u.corrupt {
+		return -1
+	}
+	m := u.recvWindow.get(u.currentRecvIDMin)
+	if m == nil || m.id != u.currentRecvIDMin {
+		return 0
+	}
 	return m.sz
 }
 
@@ -98,42 +383,42 @@ func (u *RUDP) Recv(buffer []byte) int {
 // or when a new package is coming.
 // received is the actual udp package we received
 // sz is the size of the package
-// the package returned from this function should be sent out.
+// the package returned from this function should be sent out,
+// and may be released afterwards.
 func (u *RUDP) Update(received []byte, sz int, deltaTick int) *RUDPPackage {
 	u.currentTick += deltaTick
 	u.clearOutPackage()
 	if sz > len(received) {
 		sz = len(received)
 	}
-	u.extractPackages(received, sz)
+	u.unwrapPackage(received, sz)
 
 	if u.currentTick >= u.lastExpiredTick+u.ExpiredTime {
 		u.clearSendExpired(u.lastExpiredTick)
 		u.lastExpiredTick = u.currentTick
 	}
 	if u.currentTick >= u.lastSendTick+u.SendDelay {
-		u.sendPackage = u.genOutPackage()
+		u.sendPackage = u.wrapPackages(u.genOutPackage())
 		u.lastSendTick = u.currentTick
 		return u.sendPackage
 	}
 	return nil
 }
 
+// Stats returns the counters collected so far
+func (u *RUDP) Stats() Stats {
+	return u.stats
+}
+
 func (u *RUDP) DebugGetPoolSize() int {
-	n := 0
-	m := u.messagePool
-	for m != nil {
-		n++
-		m = m.next
-	}
-	return n
+	return u.messagePool.count
 }
 
 type message struct {
 	next   *message
 	buffer []byte
 	sz     int
-	id     uint16
+	id     uint32
 	tick   int
 }
 
@@ -152,48 +437,18 @@ func (q *messageQueue) push(m *message) {
 	}
 }
 
-func (q *messageQueue) pop(id uint16) *message {
-	if q.head == nil {
-		return nil
-	}
-	m := q.head
-	if m.id != id {
-		return nil
-	}
-	q.head = m.next
-	m.next = nil
-	if q.head == nil {
-		q.tail = nil
-	}
-	return m
-}
-
 func (u *RUDP) clearOutPackage() {
 	u.sendPackage = nil
 }
 
 // createMessage is called in following 2 cases
 // 1. when sending message, we create message and push to sendQueue
-// 2. when message received, we create messages and push to recvQueue
+// 2. when message received, we create messages and put them in recvWindow
 func (u *RUDP) createMessage(buffer []byte, sz int) *message {
-	msg := u.messagePool
-	if msg != nil {
-		u.messagePool = msg.next
-		if len(msg.buffer) < sz {
-			msg.buffer = make([]byte, sz)
-		}
-	} else {
-		msg = &message{}
-		if sz > 0 {
-			msg.buffer = make([]byte, sz)
-		} else {
-			msg.buffer = make([]byte, u.mtu)
-		}
-	}
-
+	msg := u.messagePool.get(sz)
 	msg.sz = sz
 	if buffer != nil {
-		copy(msg.buffer, buffer)
+		copy(msg.buffer, buffer[:sz])
 	}
 	msg.tick = 0
 	msg.id = 0
@@ -202,25 +457,17 @@ func (u *RUDP) createMessage(buffer []byte, sz int) *message {
 }
 
 func (u *RUDP) deleteMessage(m *message) {
-	m.next = u.messagePool
-	u.messagePool = m
+	u.messagePool.put(m)
 }
 
 func (u *RUDP) clearSendExpired(tick int) {
 	m := u.sendHistroy.head
-	var last *message
-	for m != nil {
-		if m.tick >= tick {
-			break
-		}
-		last = m
-		m = m.next
-	}
-
-	if last != nil {
+	for m != nil && m.tick < tick {
 		// free all the messages before tick
-		last.next = u.messagePool
-		u.messagePool = u.sendHistroy.head
+		next := m.next
+		u.sendIndex.take(m.id)
+		u.deleteMessage(m)
+		m = next
 	}
 	u.sendHistroy.head = m
 	if m == nil {
@@ -228,61 +475,97 @@ func (u *RUDP) clearSendExpired(tick int) {
 	}
 }
 
-func compareID(srcID uint16, destID uint16) int {
-	src := int(srcID)
-	dest := int(destID)
-	if src-dest > 0x8000 || src-dest < -0x8000 {
-		return dest - src
+// compareID returns the signed distance from destID to srcID,
+// treating ids as circular within the configured id width
+func (u *RUDP) compareID(srcID uint32, destID uint32) int {
+	diff := (srcID - destID) & u.idMask
+	if diff < u.idHalf || (diff == u.idHalf && srcID > destID) {
+		return int(diff)
 	}
-	return src - dest
+	return -int(u.idMask - diff + 1)
 }
 
-func (u *RUDP) getID(buffer []byte) uint16 {
-	return binary.BigEndian.Uint16(buffer)
+func (u *RUDP) nextID(id uint32) uint32 {
+	return (id + 1) & u.idMask
 }
 
-func (u *RUDP) addRequest(id uint16) {
+func (u *RUDP) getID(buffer []byte) uint32 {
+	if u.idWidth == IDWidth32 {
+		return binary.BigEndian.Uint32(buffer)
+	}
+	return uint32(binary.BigEndian.Uint16(buffer))
+}
+
+func (u *RUDP) addRequest(id uint32) {
 	u.sendAgain = append(u.sendAgain, id)
 }
 
-func (u *RUDP) addMissing(id uint16) {
+func (u *RUDP) addMissing(id uint32) {
 	u.insertMessageToRecvQueue(id, nil, -1)
 }
 
-func (u *RUDP) insertMessageToRecvQueue(id uint16, buffer []byte, sz int) {
-	if compareID(id, u.currentRecvIDMin) < 0 {
-		fmt.Printf(
-			"Failed to insert msg with id %v as it's less than current min id.\n", id)
+// unwrapPackage undoes the optional package level features of a received
+// package before its messages are extracted
+func (u *RUDP) unwrapPackage(buffer []byte, sz int) {
+	if sz <= 0 {
 		return
 	}
-	if compareID(id, u.currentRecvIDMax) > 0 || u.recvQueue.head == nil {
-		m := u.createMessage(buffer, sz)
-		m.id = id
-		u.recvQueue.push(m)
-		u.currentRecvIDMax = id
-	} else {
-		m := u.recvQueue.head
-		last := &u.recvQueue.head
-		for {
-			if compareID(m.id, id) > 0 {
-				tmp := u.createMessage(buffer, sz)
-				tmp.id = id
-				tmp.next = m
-				*last = tmp
-				return
-			} else if m.id == id {
-				// Duplicated message
-				return
-			}
-			last = &m.next
-			m = m.next
+	buffer = buffer[:sz]
+	if u.sealer != nil {
+		var ok bool
+		if buffer, ok = u.openPackage(buffer); !ok {
+			return
+		}
+	}
+	if u.checksum {
+		var ok bool
+		if buffer, ok = u.verifyChecksum(buffer); !ok {
+			return
+		}
+	}
+	if u.fecDecoder != nil {
+		u.fecDecode(buffer)
+		return
+	}
+	u.extractPayload(buffer)
+}
 
-			if m == nil {
-				fmt.Println("Error::Should never be here unless bug.")
-				break
-			}
+// extractPayload decompresses a package if needed and extracts its messages
+func (u *RUDP) extractPayload(buffer []byte) {
+	if u.compressor != nil {
+		var ok bool
+		if buffer, ok = u.compressor.decompress(buffer); !ok {
+			u.corrupt = true
+			return
 		}
 	}
+	u.extractPackages(buffer, len(buffer))
+}
+
+// wrapPackages applies the optional package level features to the packages
+// generated by genOutPackage, every step releases the packages it replaces
+func (u *RUDP) wrapPackages(p *RUDPPackage) *RUDPPackage {
+	if u.compressor != nil {
+		q := u.compressor.compress(p)
+		p.Release()
+		p = q
+	}
+	if u.fecEncoder != nil {
+		q := u.fecEncoder.encode(p)
+		p.Release()
+		p = q
+	}
+	if u.checksum {
+		q := appendChecksums(p)
+		p.Release()
+		p = q
+	}
+	if u.sealer != nil {
+		q := u.sealer.seal(p)
+		p.Release()
+		p = q
+	}
+	return p
 }
 
 func (u *RUDP) extractPackages(buffer []byte, sz int) {
@@ -313,8 +596,8 @@ func (u *RUDP) extractPackages(buffer []byte, sz int) {
 			u.corrupt = true
 			return
 		case TypeRequest, TypeMissing:
-			// | tag (1 byte) | id (2 bytes) |
-			if sz < 2 {
+			// | tag (1 byte) | id (2 or 4 bytes) |
+			if sz < u.idWidth {
 				u.corrupt = true
 				return
 			}
@@ -324,20 +607,20 @@ func (u *RUDP) extractPackages(buffer []byte, sz int) {
 			} else {
 				u.addMissing(id)
 			}
-			buffer = buffer[2:]
-			sz -= 2
+			buffer = buffer[u.idWidth:]
+			sz -= u.idWidth
 		default:
-			// | tag (1~2 bytes) | id (2 bytes) | data |
+			// | tag (1~2 bytes) | id (2 or 4 bytes) | data |
 			// data is at least 1 byte, so general msg's tag starts from 1
 			dataLength := int(tag - TypeNormal)
-			if sz < dataLength+2 {
+			if dataLength > u.maxMsgSize || sz < dataLength+u.idWidth {
 				u.corrupt = true
 				return
 			}
 			id := u.getID(buffer)
-			u.insertMessageToRecvQueue(id, buffer[2:], dataLength)
-			buffer = buffer[dataLength+2:]
-			sz -= dataLength + 2
+			u.insertMessageToRecvQueue(id, buffer[u.idWidth:], dataLength)
+			buffer = buffer[dataLength+u.idWidth:]
+			sz -= dataLength + u.idWidth
 		}
 	}
 }
@@ -350,10 +633,7 @@ type tmpBuffer struct {
 }
 
 func (tmp *tmpBuffer) createEmptyPackage(sz int) *RUDPPackage {
-	p := &RUDPPackage{}
-	p.Next = nil
-	p.Buffer = make([]byte, sz)
-	p.Size = sz
+	p := takePackage(sz)
 	if tmp.tail == nil {
 		tmp.tail = p
 		tmp.head = p
@@ -372,14 +652,16 @@ func (tmp *tmpBuffer) createPackageFromBuffer() *RUDPPackage {
 }
 
 /*
-	1. request missing ( lookup RUDP::recvQueue )
-	2. reply request ( RUDP::sendAgain )
-	3. send message ( RUDP::sendQueue )
-	4. send heartbeat
+1. request missing ( lookup RUDP::recvWindow )
+2. reply request ( RUDP::sendAgain )
+3. send message ( RUDP::sendQueue )
+4. send heartbeat
 */
 func (u *RUDP) genOutPackage() *RUDPPackage {
-	tmp := &tmpBuffer{}
-	tmp.buffer = make([]byte, u.mtu)
+	tmp := &u.out
+	tmp.sz = 0
+	tmp.head = nil
+	tmp.tail = nil
 
 	u.requestMissing(tmp)
 	u.replyRequest(tmp)
@@ -395,64 +677,72 @@ func (u *RUDP) genOutPackage() *RUDPPackage {
 	return tmp.head
 }
 
-// consumer requests missing packets
-func (u *RUDP) requestMissing(tmp *tmpBuffer) {
-	id := u.currentRecvIDMin
-	m := u.recvQueue.head
-	for m != nil {
-		if compareID(m.id, id) > 0 {
-			for i := id; compareID(i, m.id) < 0; i++ {
-				u.packRequest(tmp, i, TypeRequest)
-			}
-		}
-		id = m.id + 1
-		m = m.next
-	}
-}
-
 // provider replies missing packets requests from consumer
 func (u *RUDP) replyRequest(tmp *tmpBuffer) {
-	history := u.sendHistroy.head
-	for i := 0; i < len(u.sendAgain); i++ {
-		id := u.sendAgain[i]
-		for {
-			if history == nil || compareID(id, history.id) < 0 {
-				// expired
-				u.packRequest(tmp, id, TypeMissing)
-				break
-			} else if id == history.id {
-				u.packMessage(tmp, history)
-				break
-			}
-			history = history.next
+	for _, id := range u.sendAgain {
+		m := u.sendIndex.get(id)
+		if m == nil || m.id != id {
+			// expired
+			u.packRequest(tmp, id, TypeMissing)
+			continue
 		}
+		u.packMessage(tmp, m)
+		u.stats.Retransmitted++
 	}
 
-	u.sendAgain = make([]uint16, 0)
+	u.sendAgain = u.sendAgain[:0]
 }
 
+// sendMessage packs queued messages until one falls outside the send window,
+// the rest stay in sendQueue until history expires and the window moves on.
+// While stalled the newest message of history is sent again: the receiver
+// only sees a gap when a later id arrives, so a lost tail would otherwise
+// go unnoticed until it expired and could only be reported missing.
 func (u *RUDP) sendMessage(tmp *tmpBuffer) {
+	oldest := u.sendQueue.head
+	if u.sendHistroy.head != nil {
+		oldest = u.sendHistroy.head
+	}
 	m := u.sendQueue.head
+	var last *message
 	for m != nil {
+		if (m.id-oldest.id)&u.idMask >= u.sendWindow {
+			u.stats.WindowStalls++
+			break
+		}
+		if m.tick < u.lastSendTick {
+			// held back by the window, keep it in history from now on
+			m.tick = u.currentTick
+		}
 		u.packMessage(tmp, m)
+		u.sendIndex.reserve(int((m.id - oldest.id) & u.idMask))
+		u.sendIndex.put(m)
+		last = m
 		m = m.next
 	}
 
-	if u.sendQueue.head != nil {
+	if m != nil && last == nil && u.sendHistroy.tail != nil {
+		u.packMessage(tmp, u.sendHistroy.tail)
+		u.stats.TailProbes++
+	}
+	if last != nil {
 		if u.sendHistroy.tail == nil {
-			u.sendHistroy = u.sendQueue
+			u.sendHistroy.head = u.sendQueue.head
 		} else {
 			u.sendHistroy.tail.next = u.sendQueue.head
-			u.sendHistroy.tail = u.sendQueue.tail
 		}
-		u.sendQueue.head = nil
-		u.sendQueue.tail = nil
+		u.sendHistroy.tail = last
+		last.next = nil
+		u.sendQueue.head = m
+		if m == nil {
+			u.sendQueue.tail = nil
+		}
 	}
 }
 
-func (u *RUDP) packRequest(tmp *tmpBuffer, id uint16, tag int) {
+func (u *RUDP) packRequest(tmp *tmpBuffer, id uint32, tag int) {
 	sz := u.mtu - tmp.sz
-	if sz < 3 {
+	if sz < 1+u.idWidth {
 		tmp.createPackageFromBuffer()
 	}
 	buffer := tmp.buffer[tmp.sz:]
@@ -460,22 +750,20 @@ func (u *RUDP) packRequest(tmp *tmpBuffer, id uint16, tag int) {
 }
 
 func (u *RUDP) packMessage(tmp *tmpBuffer, m *message) {
-	if m.sz > u.mtu-4 {
+	headerSize := 2 + u.idWidth
+	if m.sz > u.mtu-headerSize {
 		if tmp.sz > 0 {
 			tmp.createPackageFromBuffer()
 		}
 		// big package
-		sz := 4 + m.sz
+		sz := headerSize + m.sz
 		p := tmp.createEmptyPackage(sz)
-		p.Next = nil
-		p.Buffer = make([]byte, sz)
-		p.Size = sz
 		u.fillHeader(p.Buffer, m.sz+TypeNormal, m.id)
-		copy(p.Buffer[4:], m.buffer[:m.sz])
+		copy(p.Buffer[headerSize:], m.buffer[:m.sz])
 		return
 	}
 	// the remaining size is not enough to hold the message
-	if u.mtu-tmp.sz < 4+m.sz {
+	if u.mtu-tmp.sz < headerSize+m.sz {
 		tmp.createPackageFromBuffer()
 	}
 	buf := tmp.buffer[tmp.sz:]
@@ -484,7 +772,7 @@ func (u *RUDP) packMessage(tmp *tmpBuffer, m *message) {
 	tmp.sz += length + m.sz
 }
 
-func (u *RUDP) fillHeader(buffer []byte, length int, id uint16) int {
+func (u *RUDP) fillHeader(buffer []byte, length int, id uint32) int {
 	var sz int
 	if length < 128 {
 		buffer[0] = byte(length)
@@ -493,6 +781,10 @@ func (u *RUDP) fillHeader(buffer []byte, length int, id uint16) int {
 		binary.BigEndian.PutUint16(buffer, uint16(length)+0x8000)
 		sz = 2
 	}
-	binary.BigEndian.PutUint16(buffer[sz:], uint16(id))
-	return sz + 2
+	if u.idWidth == IDWidth32 {
+		binary.BigEndian.PutUint32(buffer[sz:], id)
+	} else {
+		binary.BigEndian.PutUint16(buffer[sz:], uint16(id))
+	}
+	return sz + u.idWidth
 }
@@ -3,6 +3,7 @@ package rudp_test
 import (
 	"bytes"
 	"fmt"
+	"net"
 	"testing"
 
 	"encoding/binary"
@@ -488,3 +489,258 @@ func TestBigID(t *testing.T) {
 	}
 	dump(p)
 }
+
+func TestIDWidth32(t *testing.T) {
+	fmt.Println("=======================TestIDWidth32======================")
+
+	idx = 0
+	U := rudp.CreateWithOptions(1, 5, 128, rudp.Options{IDWidth: rudp.IDWidth32})
+
+	U.Send([]byte{1, 2, 3, 4}, 4)
+	p := U.Update(nil, 0, 1)
+	if p == nil || p.Next != nil ||
+		bytes.Compare(p.Buffer, []byte{8, 0, 0, 0, 0, 1, 2, 3, 4}) != 0 {
+		t.Error("RUDP::Update error, should send 1 package with 4-byte id.")
+	}
+	dump(p)
+
+	r1 := []byte{
+		5, 0, 0, 0, 1, 1,
+		5, 0, 0, 0, 3, 3,
+	}
+	p = U.Update(r1, len(r1), 1)
+	if p == nil || p.Next != nil ||
+		bytes.Compare(p.Buffer, []byte{
+			rudp.TypeRequest, 0, 0, 0, 0,
+			rudp.TypeRequest, 0, 0, 0, 2}) != 0 {
+		t.Error("RUDP::Update error, should request 2 missing 4-byte ids.")
+	}
+	dump(p)
+
+	r2 := []byte{
+		5, 0, 0, 0, 0, 0,
+		5, 0, 0, 0, 2, 2,
+		rudp.TypeRequest, 0, 0, 0, 0,
+	}
+	p = U.Update(r2, len(r2), 1)
+	if p == nil || p.Next != nil ||
+		bytes.Compare(p.Buffer, []byte{8, 0, 0, 0, 0, 1, 2, 3, 4}) != 0 {
+		t.Error("RUDP::Update error, should resend message 0 with 4-byte id.")
+	}
+	dump(p)
+
+	recvResult := dumpRecv(U)
+	if recvResult != "RECV 0\nRECV 1\nRECV 2\nRECV 3\n" {
+		t.Error("RUDP:Recv error: should receive 0~3 messages.")
+	}
+
+	r3 := []byte{5, 0, 0, 1}
+	U.Update(r3, len(r3), 1)
+	if dumpRecv(U) != "CORRUPT\n" {
+		t.Error("Should get a corrupt signal for a truncated 4-byte id.")
+	}
+}
+
+func TestSendWindow(t *testing.T) {
+	fmt.Println("=======================TestSendWindow======================")
+
+	idx = 0
+	U := rudp.CreateWithOptions(1, 5, 128, rudp.Options{SendWindow: 4})
+
+	for i := 0; i < 6; i++ {
+		U.Send([]byte{byte(i)}, 1)
+	}
+	p := U.Update(nil, 0, 1)
+	if p == nil || p.Next != nil ||
+		bytes.Compare(p.Buffer, []byte{
+			5, 0, 0, 0, 5, 0, 1, 1, 5, 0, 2, 2, 5, 0, 3, 3}) != 0 {
+		t.Error("RUDP::Update error, should only send messages inside the window.")
+	}
+	dump(p)
+	if U.Stats().WindowStalls != 1 {
+		t.Error("RUDP::Stats error, window should have stalled once.")
+	}
+
+	p = U.Update(nil, 0, 1)
+	if p == nil || p.Next != nil ||
+		bytes.Compare(p.Buffer, []byte{5, 0, 3, 3}) != 0 {
+		t.Error("RUDP::Update error, should probe the newest message while window is full.")
+	}
+	dump(p)
+
+	dump(U.Update(nil, 0, 5))
+	p = U.Update(nil, 0, 5) // history expires and the window moves on
+	if p == nil || p.Next != nil ||
+		bytes.Compare(p.Buffer, []byte{5, 0, 4, 4, 5, 0, 5, 5}) != 0 {
+		t.Error("RUDP::Update error, should send held messages after history expires.")
+	}
+	dump(p)
+	if U.Stats().WindowStalls != 3 {
+		t.Error("RUDP::Stats error, window should have stalled 3 times.")
+	}
+	if U.Stats().TailProbes != 2 {
+		t.Error("RUDP::Stats error, newest message should have been probed twice.")
+	}
+
+	r := []byte{rudp.TypeRequest, 0, 4}
+	p = U.Update(r, len(r), 1)
+	if p == nil || p.Next != nil ||
+		bytes.Compare(p.Buffer, []byte{5, 0, 4, 4}) != 0 {
+		t.Error("RUDP::Update error, held messages should stay in history once sent.")
+	}
+	dump(p)
+}
+
+func TestRetransmitStats(t *testing.T) {
+	fmt.Println("=======================TestRetransmitStats======================")
+
+	idx = 0
+	U := rudp.Create(1, 5, 128)
+
+	U.Send([]byte{1, 2, 3, 4}, 4)
+	dump(U.Update(nil, 0, 1))
+
+	r := []byte{
+		rudp.TypeRequest, 0, 0,
+		rudp.TypeRequest, 0, 1,
+	}
+	dump(U.Update(r, len(r), 1))
+	if U.Stats().Retransmitted != 1 {
+		t.Error("RUDP::Stats error, should retransmit 1 message.")
+	}
+}
+
+func TestUnsortedRequests(t *testing.T) {
+	fmt.Println("=======================TestUnsortedRequests======================")
+
+	idx = 0
+	U := rudp.Create(1, 5, 128)
+
+	U.Send([]byte{1}, 1)
+	U.Send([]byte{2}, 1)
+	U.Send([]byte{3}, 1)
+	dump(U.Update(nil, 0, 1))
+
+	r := []byte{
+		rudp.TypeRequest, 0, 2,
+		rudp.TypeRequest, 0, 0,
+		rudp.TypeRequest, 0, 1,
+	}
+	p := U.Update(r, len(r), 1)
+	if p == nil || p.Next != nil || bytes.Compare(p.Buffer, []byte{
+		5, 0, 2, 3,
+		5, 0, 0, 1,
+		5, 0, 1, 2,
+	}) != 0 {
+		t.Error("RUDP::Update error, should resend messages 2, 0 and 1 in request order.")
+	}
+	dump(p)
+	if U.Stats().Retransmitted != 3 {
+		t.Error("RUDP::Stats error, should retransmit 3 messages.")
+	}
+}
+
+func TestSendV(t *testing.T) {
+	fmt.Println("=======================TestSendV======================")
+
+	idx = 0
+	U := rudp.Create(1, 5, 128)
+
+	U.SendV([][]byte{{1, 2}, nil, {3}})
+	U.SendBuffers(net.Buffers{{4}, {5, 6, 7}})
+	p := U.Update(nil, 0, 1)
+	if p == nil || p.Next != nil || bytes.Compare(p.Buffer, []byte{
+		7, 0, 0, 1, 2, 3,
+		8, 0, 1, 4, 5, 6, 7,
+	}) != 0 {
+		t.Error("RUDP::Update error, should send the joined messages.")
+	}
+	dump(p)
+
+	header := []byte{9, 9}
+	body := make([]byte, 100)
+	if n := testing.AllocsPerRun(100, func() {
+		U.SendV([][]byte{header, body})
+		U.Update(nil, 0, 10).Release()
+	}); n != 0 && !raceEnabled {
+		t.Errorf("RUDP::SendV error, %v allocations per message.", n)
+	}
+}
+
+func TestSendWindowTailLoss(t *testing.T) {
+	fmt.Println("=======================TestSendWindowTailLoss======================")
+
+	A := rudp.CreateWithOptions(1, 5, 128, rudp.Options{SendWindow: 4})
+	B := rudp.Create(1, 5, 128)
+	tmp := make([]byte, rudp.MaxPackageSize)
+	next := 0
+	for tick := 0; tick < 60; tick++ {
+		if tick < 20 {
+			A.Send([]byte{byte(tick)}, 1)
+		}
+		p := A.Update(nil, 0, 1)
+		for q := p; q != nil; q = q.Next {
+			// the last message before the window stalls is lost once
+			if tick == 3 {
+				continue
+			}
+			B.Update(q.Buffer, q.Size, 0)
+		}
+		p.Release()
+		p = B.Update(nil, 0, 1)
+		for q := p; q != nil; q = q.Next {
+			A.Update(q.Buffer, q.Size, 0)
+		}
+		p.Release()
+		for n := B.Recv(tmp); n != 0; n = B.Recv(tmp) {
+			if n < 0 || tmp[0] != byte(next) {
+				t.Fatalf("RUDP::Recv error, expect message %d, got size %d.", next, n)
+			}
+			next++
+		}
+	}
+	if next != 20 {
+		t.Errorf("RUDP::Recv error, received %d of 20 messages.", next)
+	}
+}
+
+func TestRecvMessage(t *testing.T) {
+	fmt.Println("=======================TestRecvMessage======================")
+
+	U := rudp.Create(1, 5, 512)
+	if U.NextSize() != 0 {
+		t.Error("RUDP::NextSize error, nothing has been received.")
+	}
+	big := make([]byte, 1000)
+	for i := range big {
+		big[i] = byte(i)
+	}
+	A := rudp.Create(1, 5, 512)
+	A.Send(big, len(big))
+	exchange(A, U)
+
+	if n := U.NextSize(); n != len(big) {
+		t.Errorf("RUDP::NextSize error, should be %d not %d.", len(big), n)
+	}
+	// a short buffer leaves the message queued
+	if n := U.Recv(make([]byte, 10)); n != -2 || U.NextSize() != len(big) {
+		t.Error("RUDP::Recv error, short buffer should be reported.")
+	}
+	m, err := U.RecvMessage()
+	if err != nil || m == nil || !bytes.Equal(m.Bytes(), big) {
+		t.Error("RUDP::RecvMessage error, should receive the big message.")
+	}
+	m.Release()
+	if m, err := U.RecvMessage(); m != nil || err != nil {
+		t.Error("RUDP::RecvMessage error, should have no more message.")
+	}
+
+	r := []byte{rudp.TypeMissing, 0, 1}
+	U.Update(r, len(r), 0)
+	if U.NextSize() != -1 {
+		t.Error("RUDP::NextSize error, message 1 is missing.")
+	}
+	if _, err := U.RecvMessage(); err != rudp.ErrCorrupt {
+		t.Error("RUDP::RecvMessage error, should report the corrupted connection.")
+	}
+}
@@ -0,0 +1,38 @@
+package rudptest_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/bennychen/rudp/rudptest"
+)
+
+func TestFakeClock(t *testing.T) {
+	start := time.Unix(1000, 0)
+	clock := rudptest.NewFakeClock(start)
+
+	var fired []time.Duration
+	record := func() { fired = append(fired, clock.Now().Sub(start)) }
+	clock.AfterFunc(3*time.Second, record)
+	clock.AfterFunc(time.Second, func() {
+		record()
+		clock.AfterFunc(time.Second, record)
+	})
+	stopped := clock.AfterFunc(2*time.Second, record)
+	if !stopped.Stop() || stopped.Stop() {
+		t.Error("FakeClock error, timer should only stop once.")
+	}
+
+	clock.Advance(2500 * time.Millisecond)
+	if len(fired) != 2 || fired[0] != time.Second || fired[1] != 2*time.Second {
+		t.Errorf("FakeClock error, unexpected timers fired %v.", fired)
+	}
+	if clock.Now().Sub(start) != 2500*time.Millisecond {
+		t.Error("FakeClock error, clock should end at the advanced time.")
+	}
+
+	clock.Advance(time.Second)
+	if len(fired) != 3 || fired[2] != 3*time.Second {
+		t.Errorf("FakeClock error, unexpected timers fired %v.", fired)
+	}
+}
@@ -0,0 +1,191 @@
+package rudptest
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+
+	"github.com/bennychen/rudp"
+)
+
+// LinkConfig describes the impairments of one direction of a Link, the
+// zero value is a perfect link delivering packages on the next tick
+type LinkConfig struct {
+	Loss      float64 // probability a package is dropped
+	Duplicate float64 // probability a package is delivered twice
+	Delay     int     // ticks every package spends on the link
+	Jitter    int     // up to this many extra ticks, picked per package
+
+	// Reorder is the probability a package is held back ReorderDelay more
+	// ticks, so packages sent after it overtake it. 0 ReorderDelay means
+	// Delay+Jitter+1
+	Reorder      float64
+	ReorderDelay int
+
+	// Bandwidth limits the link to this many bytes per tick, packages wait
+	// for the ones before them. 0 means unlimited
+	Bandwidth int
+	// QueueLimit drops packages arriving while this many bytes wait for
+	// bandwidth already, 0 means unlimited
+	QueueLimit int
+}
+
+// LinkStats counts what happened to the packages of one direction
+type LinkStats struct {
+	Sent       int // packages handed to the link
+	Dropped    int // lost or dropped by a full queue
+	Duplicated int
+	Reordered  int
+	Delivered  int
+}
+
+// Link connects two RUDP objects through simulated impairments. It is
+// driven by a virtual clock of whole ticks, all randomness comes from the
+// seed so a failing run can be repeated exactly.
+type Link struct {
+	A, B *rudp.RUDP
+
+	tick int
+	rand *rand.Rand
+	ab   direction // from A to B
+	ba   direction // from B to A
+}
+
+type direction struct {
+	config  LinkConfig
+	stats   LinkStats
+	busy    float64 // tick when the bandwidth is free again
+	pending []inflight
+}
+
+type inflight struct {
+	arrive int
+	data   []byte
+}
+
+// NewLink connects a and b, ab impairs packages from a to b and ba the
+// ones from b to a
+func NewLink(a, b *rudp.RUDP, ab, ba LinkConfig, seed int64) *Link {
+	return &Link{
+		A:    a,
+		B:    b,
+		rand: rand.New(rand.NewSource(seed)),
+		ab:   direction{config: ab},
+		ba:   direction{config: ba},
+	}
+}
+
+// Tick returns the ticks run so far
+func (l *Link) Tick() int {
+	return l.tick
+}
+
+// Stats returns the counters of both directions
+func (l *Link) Stats() (ab, ba LinkStats) {
+	return l.ab.stats, l.ba.stats
+}
+
+// Step runs one tick: packages due are delivered, then both ends are
+// updated and what they send enters the link
+func (l *Link) Step() {
+	l.tick++
+	l.deliver(&l.ab, l.B, &l.ba)
+	l.deliver(&l.ba, l.A, &l.ab)
+	l.send(&l.ab, l.A.Update(nil, 0, 1))
+	l.send(&l.ba, l.B.Update(nil, 0, 1))
+}
+
+// Run runs n ticks
+func (l *Link) Run(n int) {
+	for i := 0; i < n; i++ {
+		l.Step()
+	}
+}
+
+// RunUntil runs ticks until done returns true or max ticks have run, it
+// reports whether done returned true
+func (l *Link) RunUntil(max int, done func() bool) bool {
+	for i := 0; i < max; i++ {
+		if done() {
+			return true
+		}
+		l.Step()
+	}
+	return done()
+}
+
+// deliver hands the packages of d due by now to to, its replies go back
+// through reply
+func (l *Link) deliver(d *direction, to *rudp.RUDP, reply *direction) {
+	n := 0
+	for n < len(d.pending) && d.pending[n].arrive <= l.tick {
+		n++
+	}
+	due := d.pending[:n]
+	d.pending = d.pending[n:]
+	for _, f := range due {
+		d.stats.Delivered++
+		l.send(reply, to.Update(f.data, len(f.data), 0))
+	}
+}
+
+// send puts the package chain p on d and releases it
+func (l *Link) send(d *direction, p *rudp.RUDPPackage) {
+	for q := p; q != nil; q = q.Next {
+		l.transmit(d, q.Buffer[:q.Size])
+	}
+	p.Release()
+}
+
+func (l *Link) transmit(d *direction, data []byte) {
+	c := &d.config
+	d.stats.Sent++
+	if c.Loss > 0 && l.rand.Float64() < c.Loss {
+		d.stats.Dropped++
+		return
+	}
+
+	now := float64(l.tick)
+	if d.busy < now {
+		d.busy = now
+	}
+	if c.Bandwidth > 0 {
+		queued := (d.busy - now) * float64(c.Bandwidth)
+		if c.QueueLimit > 0 && queued > float64(c.QueueLimit) {
+			d.stats.Dropped++
+			return
+		}
+		d.busy += float64(len(data)) / float64(c.Bandwidth)
+	}
+
+	copies := 1
+	if c.Duplicate > 0 && l.rand.Float64() < c.Duplicate {
+		d.stats.Duplicated++
+		copies = 2
+	}
+	for i := 0; i < copies; i++ {
+		// the bandwidth is spent once, the copy appears on the way
+		arrive := int(math.Ceil(d.busy)) + c.Delay
+		if arrive <= l.tick {
+			arrive = l.tick + 1
+		}
+		if c.Jitter > 0 {
+			arrive += l.rand.Intn(c.Jitter + 1)
+		}
+		if c.Reorder > 0 && l.rand.Float64() < c.Reorder {
+			d.stats.Reordered++
+			extra := c.ReorderDelay
+			if extra <= 0 {
+				extra = c.Delay + c.Jitter + 1
+			}
+			arrive += extra
+		}
+		// after every package arriving on the same tick or before
+		n := sort.Search(len(d.pending), func(i int) bool {
+			return d.pending[i].arrive > arrive
+		})
+		d.pending = append(d.pending, inflight{})
+		copy(d.pending[n+1:], d.pending[n:])
+		d.pending[n] = inflight{arrive: arrive, data: append([]byte(nil), data...)}
+	}
+}
@@ -0,0 +1,95 @@
+package rudptest_test
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"github.com/bennychen/rudp"
+	"github.com/bennychen/rudp/rudptest"
+)
+
+// transfer sends n numbered messages of size bytes from A to B over a link
+// and returns the link once B received them all, in order. B sends every
+// sendDelay ticks.
+func transfer(t *testing.T, ab, ba rudptest.LinkConfig, seed int64,
+	sendDelay, n, size int) *rudptest.Link {
+	l := rudptest.NewLink(rudp.Create(1, 1000, 512), rudp.Create(sendDelay, 1000, 512),
+		ab, ba, seed)
+	msg := make([]byte, size)
+	for i := 0; i < n; i++ {
+		binary.BigEndian.PutUint32(msg, uint32(i))
+		l.A.Send(msg, len(msg))
+	}
+	tmp := make([]byte, rudp.MaxPackageSize)
+	next := 0
+	done := l.RunUntil(10000, func() bool {
+		for sz := l.B.Recv(tmp); sz != 0; sz = l.B.Recv(tmp) {
+			if sz != size || int(binary.BigEndian.Uint32(tmp)) != next {
+				t.Fatalf("Link error, message %d arrived broken or out of order.", next)
+			}
+			next++
+		}
+		return next == n
+	})
+	if !done {
+		t.Fatalf("Link error, only %d of %d messages arrived.", next, n)
+	}
+	return l
+}
+
+func TestLinkPerfect(t *testing.T) {
+	l := transfer(t, rudptest.LinkConfig{}, rudptest.LinkConfig{}, 1, 1, 1, 4)
+	// sent on the first tick, delivered on the next
+	if l.Tick() != 2 {
+		t.Errorf("Link error, message should arrive on tick 2 not %d.", l.Tick())
+	}
+	ab, _ := l.Stats()
+	if ab.Delivered != 1 || ab.Dropped != 0 {
+		t.Errorf("Link error, unexpected stats %+v.", ab)
+	}
+}
+
+func TestLinkImpaired(t *testing.T) {
+	bad := rudptest.LinkConfig{
+		Loss:      0.2,
+		Duplicate: 0.1,
+		Delay:     3,
+		Jitter:    4,
+		Reorder:   0.1,
+	}
+	l := transfer(t, bad, bad, 42, 1, 300, 100)
+	ab, ba := l.Stats()
+	if ab.Dropped == 0 || ab.Duplicated == 0 || ab.Reordered == 0 || ba.Dropped == 0 {
+		t.Errorf("Link error, impairments should have happened: %+v %+v.", ab, ba)
+	}
+
+	// the same seed repeats the run exactly
+	again := transfer(t, bad, bad, 42, 1, 300, 100)
+	ab2, ba2 := again.Stats()
+	if again.Tick() != l.Tick() || ab2 != ab || ba2 != ba {
+		t.Error("Link error, the same seed should give the same run.")
+	}
+}
+
+func TestLinkBandwidth(t *testing.T) {
+	// heartbeats of B make A send its first message again, fewer of them
+	// leave bandwidth for the transfer
+	slow := rudptest.LinkConfig{Bandwidth: 100}
+	l := transfer(t, slow, rudptest.LinkConfig{}, 1, 10, 10, 400)
+	if l.Tick() < 41 {
+		t.Errorf("Link error, 4040 bytes at 100 per tick took %d ticks.", l.Tick())
+	}
+
+	slow.QueueLimit = 1000
+	l = rudptest.NewLink(rudp.Create(1, 1000, 512), rudp.Create(10, 1000, 512),
+		slow, rudptest.LinkConfig{}, 1)
+	msg := make([]byte, 400)
+	for i := 0; i < 10; i++ {
+		l.A.Send(msg, len(msg))
+	}
+	l.Run(100)
+	// 3 packages fit in the queue, the other 7 of the first tick are dropped
+	if ab, _ := l.Stats(); ab.Dropped < 7 {
+		t.Errorf("Link error, a full queue should drop 7 packages, not %d.", ab.Dropped)
+	}
+}
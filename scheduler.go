@@ -0,0 +1,246 @@
+package rudp
+
+import (
+	"sync"
+	"time"
+)
+
+// the layout of the skynet timer: a near wheel of 256 ticks, then 4 levels
+// of 64 slots each covering 64 times the span of the level below
+const (
+	wheelNearShift  = 8
+	wheelNear       = 1 << wheelNearShift
+	wheelNearMask   = wheelNear - 1
+	wheelLevelShift = 6
+	wheelLevel      = 1 << wheelLevelShift
+	wheelLevelMask  = wheelLevel - 1
+)
+
+// Scheduler is a Clock running AfterFunc calls on a hierarchical timer
+// wheel, rounded up to whole ticks. Adding and stopping a call is O(1) and
+// a single timer of the underlying clock drives all of them, so thousands
+// of Conns sharing a Scheduler only wake when their RUDP is due.
+type Scheduler struct {
+	clock Clock
+	tick  time.Duration
+
+	mu      sync.Mutex
+	start   time.Time // when tick 0 began
+	passed  int64     // ticks passed since start
+	current uint32    // passed on the wheel, it wraps around
+	near    [wheelNear]*schedulerTimer
+	levels  [4][wheelLevel]*schedulerTimer
+	count   int      // pending calls
+	driver  Timer    // nil while nothing is pending
+	gen     uint64   // tells drivers of an earlier run of the wheel apart
+	running int      // drive calls running the calls of a tick
+	flushes []*Batch // flushed once the calls of the running ticks are done
+}
+
+type schedulerTimer struct {
+	s       *Scheduler
+	next    *schedulerTimer
+	expire  uint32
+	f       func()
+	stopped bool
+	fired   bool
+}
+
+// NewScheduler creates a Scheduler with the given tick on clock, a nil
+// clock means SystemClock
+func NewScheduler(tick time.Duration, clock Clock) *Scheduler {
+	if clock == nil {
+		clock = SystemClock
+	}
+	if tick <= 0 {
+		tick = defaultTick
+	}
+	return &Scheduler{clock: clock, tick: tick}
+}
+
+// Now returns the time of the underlying clock
+func (s *Scheduler) Now() time.Time {
+	return s.clock.Now()
+}
+
+// AfterFunc calls f on the first tick at least d from now
+func (s *Scheduler) AfterFunc(d time.Duration, f func()) Timer {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.clock.Now()
+	if s.count == 0 {
+		// the wheel is empty, restart it from now
+		s.start = now
+		s.passed = 0
+		s.current = 0
+	}
+	ticks := int64((now.Sub(s.start) + d + s.tick - 1) / s.tick)
+	if ticks <= s.passed {
+		ticks = s.passed + 1
+	}
+	t := &schedulerTimer{s: s, expire: s.current + uint32(ticks-s.passed), f: f}
+	s.add(t)
+	s.count++
+	if s.driver == nil {
+		s.startDriver()
+	}
+	return t
+}
+
+// Stop prevents the call, false is returned if it already happened
+func (t *schedulerTimer) Stop() bool {
+	s := t.s
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if t.fired || t.stopped {
+		return false
+	}
+	// left in its slot and skipped when the slot comes up
+	t.stopped = true
+	s.count--
+	if s.count == 0 {
+		s.reset()
+	}
+	return true
+}
+
+// add must be called with s.mu held
+func (s *Scheduler) add(t *schedulerTimer) {
+	var list **schedulerTimer
+	if t.expire|wheelNearMask == s.current|wheelNearMask {
+		list = &s.near[t.expire&wheelNearMask]
+	} else {
+		mask := uint32(wheelNear << wheelLevelShift)
+		i := 0
+		for ; i < 3; i++ {
+			if t.expire|(mask-1) == s.current|(mask-1) {
+				break
+			}
+			mask <<= wheelLevelShift
+		}
+		slot := (t.expire >> (wheelNearShift + uint(i)*wheelLevelShift)) & wheelLevelMask
+		list = &s.levels[i][slot]
+	}
+	t.next = *list
+	*list = t
+}
+
+// shift moves current one tick on and spreads the level slot reached over
+// the lower levels, it must be called with s.mu held
+func (s *Scheduler) shift() {
+	s.passed++
+	s.current++
+	ct := s.current
+	if ct == 0 {
+		s.moveList(3, 0)
+		return
+	}
+	mask := uint32(wheelNear)
+	t := ct >> wheelNearShift
+	for i := 0; ct&(mask-1) == 0; i++ {
+		if idx := t & wheelLevelMask; idx != 0 {
+			s.moveList(i, idx)
+			break
+		}
+		mask <<= wheelLevelShift
+		t >>= wheelLevelShift
+	}
+}
+
+func (s *Scheduler) moveList(level int, idx uint32) {
+	t := s.levels[level][idx]
+	s.levels[level][idx] = nil
+	for t != nil {
+		next := t.next
+		s.add(t)
+		t = next
+	}
+}
+
+// due takes the calls of the current tick, it must be called with s.mu held
+func (s *Scheduler) due(fs []func()) []func() {
+	idx := s.current & wheelNearMask
+	t := s.near[idx]
+	s.near[idx] = nil
+	for ; t != nil; t = t.next {
+		if !t.stopped {
+			t.fired = true
+			s.count--
+			fs = append(fs, t.f)
+		}
+	}
+	return fs
+}
+
+// reset empties the wheel and stops driving it, it must be called with
+// s.mu held
+func (s *Scheduler) reset() {
+	s.near = [wheelNear]*schedulerTimer{}
+	s.levels = [4][wheelLevel]*schedulerTimer{}
+	if s.driver != nil {
+		s.driver.Stop()
+		s.driver = nil
+	}
+	s.gen++
+}
+
+// startDriver must be called with s.mu held
+func (s *Scheduler) startDriver() {
+	gen := s.gen
+	s.driver = s.clock.AfterFunc(s.tick, func() {
+		s.drive(gen)
+	})
+}
+
+// drive runs on the timer of the underlying clock every tick while calls
+// are pending, ticks missed by a late wake up are caught up
+func (s *Scheduler) drive(gen uint64) {
+	var fs []func()
+	s.mu.Lock()
+	if gen != s.gen {
+		s.mu.Unlock()
+		return
+	}
+	target := int64(s.clock.Now().Sub(s.start) / s.tick)
+	for s.count > 0 && s.passed < target {
+		s.shift()
+		fs = s.due(fs)
+	}
+	if s.count > 0 {
+		s.startDriver()
+	} else {
+		s.reset()
+	}
+	s.running++
+	s.mu.Unlock()
+
+	for _, f := range fs {
+		f()
+	}
+
+	s.mu.Lock()
+	s.running--
+	bs := s.flushes
+	s.flushes = nil
+	s.mu.Unlock()
+	for _, b := range bs {
+		b.Flush()
+	}
+}
+
+// flushAfterTick queues b to be flushed once the calls of the running tick
+// are done, false is returned if no tick is running
+func (s *Scheduler) flushAfterTick(b *Batch) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.running == 0 {
+		return false
+	}
+	for _, q := range s.flushes {
+		if q == b {
+			return true
+		}
+	}
+	s.flushes = append(s.flushes, b)
+	return true
+}
@@ -0,0 +1,147 @@
+package rudp_test
+
+import (
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/bennychen/rudp"
+	"github.com/bennychen/rudp/rudptest"
+)
+
+func TestScheduler(t *testing.T) {
+	fmt.Println("=======================TestScheduler======================")
+
+	clock := rudptest.NewFakeClock(time.Unix(1000, 0))
+	start := clock.Now()
+	s := rudp.NewScheduler(time.Millisecond, clock)
+
+	// delays on the near wheel and the levels above it, the clock
+	// moves in steps of the given size when they come up
+	calls := []struct {
+		d, step time.Duration
+	}{
+		{time.Millisecond, time.Millisecond},
+		{1500 * time.Microsecond, time.Millisecond},
+		{255 * time.Millisecond, time.Millisecond},
+		{256 * time.Millisecond, time.Millisecond},
+		{1000 * time.Millisecond, time.Millisecond},
+		{20 * time.Second, time.Second},
+		{30 * time.Minute, time.Minute},
+	}
+	fired := make([]time.Duration, len(calls))
+	for i, c := range calls {
+		i := i
+		s.AfterFunc(c.d, func() {
+			fired[i] = clock.Now().Sub(start)
+		})
+	}
+	stopped := s.AfterFunc(5*time.Millisecond, func() {
+		t.Error("Scheduler error, stopped call should not run.")
+	})
+	if !stopped.Stop() || stopped.Stop() {
+		t.Error("Scheduler::Stop error, should only stop a pending call once.")
+	}
+
+	for i, c := range calls {
+		for fired[i] == 0 {
+			clock.Advance(c.step)
+		}
+		// rounded up to whole ticks, late by less than a step
+		want := (c.d + time.Millisecond - 1) / time.Millisecond * time.Millisecond
+		if fired[i] < want || fired[i] >= want+c.step {
+			t.Errorf("Scheduler error, call after %v ran after %v.", c.d, fired[i])
+		}
+	}
+}
+
+func TestSchedulerIdle(t *testing.T) {
+	fmt.Println("=======================TestSchedulerIdle======================")
+
+	clock := rudptest.NewFakeClock(time.Unix(1000, 0))
+	s := rudp.NewScheduler(time.Millisecond, clock)
+	n := 0
+	s.AfterFunc(time.Millisecond, func() { n++ })
+	clock.Advance(time.Hour) // nothing pending for most of it
+	start := clock.Now()
+	var at time.Duration
+	s.AfterFunc(10*time.Millisecond, func() {
+		n++
+		at = clock.Now().Sub(start)
+	})
+	for i := 0; i < 20; i++ {
+		clock.Advance(time.Millisecond)
+	}
+	if n != 2 || at != 10*time.Millisecond {
+		t.Errorf("Scheduler error, restarted wheel ran %d calls, the last after %v.", n, at)
+	}
+}
+
+func TestConnsOnScheduler(t *testing.T) {
+	fmt.Println("=======================TestConnsOnScheduler======================")
+
+	clock := rudptest.NewFakeClock(time.Unix(1000, 0))
+	s := rudp.NewScheduler(time.Millisecond, clock)
+	const conns = 1000
+	pcs := make([]*queueConn, conns)
+	for i := range pcs {
+		pcs[i] = &queueConn{addr: &net.UDPAddr{IP: net.IPv4(10, 0, 0, 1), Port: i}}
+		// send delays of 10 to 19 milliseconds
+		u := rudp.CreateWithDuration(time.Duration(10+i%10)*time.Millisecond,
+			time.Second, 128, rudp.Options{})
+		c := rudp.NewConn(pcs[i], pcs[i].addr, u, s)
+		defer c.Close()
+	}
+
+	clock.Advance(10 * time.Millisecond)
+	sent := 0
+	for _, pc := range pcs {
+		sent += len(pc.written)
+	}
+	if sent != conns/10 {
+		t.Errorf("Scheduler error, %d conns woke up instead of %d.", sent, conns/10)
+	}
+	for i := 0; i < 180; i++ {
+		clock.Advance(time.Millisecond)
+	}
+	for i, pc := range pcs {
+		// heartbeats every send delay
+		if want := 190 / (10 + i%10); len(pc.written) != want {
+			t.Fatalf("Scheduler error, conn %d sent %d heartbeats instead of %d.",
+				i, len(pc.written), want)
+		}
+	}
+}
+
+func TestBatchedConnsOnScheduler(t *testing.T) {
+	fmt.Println("=======================TestBatchedConnsOnScheduler======================")
+
+	clock := rudptest.NewFakeClock(time.Unix(1000, 0))
+	s := rudp.NewScheduler(time.Millisecond, clock)
+	pc := &queueConn{addr: &net.UDPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 1}}
+	b := rudp.NewBatch(pc, 64)
+
+	// calls of one tick run in no particular order, probes around the
+	// Conns see whether anything was written before the tick was done
+	var probes []int
+	probe := func() { probes = append(probes, len(pc.written)) }
+	s.AfterFunc(10*time.Millisecond, probe)
+	const conns = 8
+	for i := 0; i < conns; i++ {
+		c := rudp.NewBatchedConn(b, &net.UDPAddr{IP: net.IPv4(10, 0, 1, byte(i)), Port: 1},
+			rudp.CreateWithDuration(10*time.Millisecond, time.Second, 128, rudp.Options{}), s)
+		defer c.Close()
+		c.Send([]byte{byte(i)})
+	}
+	s.AfterFunc(10*time.Millisecond, probe)
+
+	clock.Advance(10 * time.Millisecond)
+	if len(probes) != 2 || probes[0] != 0 || probes[1] != 0 {
+		t.Errorf("Scheduler error, datagrams written during the tick: %v.", probes)
+	}
+	if len(pc.written) != conns {
+		t.Errorf("Scheduler error, flush after the tick wrote %d datagrams instead of %d.",
+			len(pc.written), conns)
+	}
+}
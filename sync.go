@@ -0,0 +1,74 @@
+package rudp
+
+import (
+	"sync"
+	"time"
+)
+
+// SyncRUDP guards a RUDP object with a mutex, so one goroutine may read the
+// socket and call Update while others call Send and Recv
+type SyncRUDP struct {
+	mu   sync.Mutex
+	rudp *RUDP
+}
+
+// NewSync wraps u, u must not be used directly afterwards
+func NewSync(u *RUDP) *SyncRUDP {
+	return &SyncRUDP{rudp: u}
+}
+
+// Send works like RUDP.Send
+func (s *SyncRUDP) Send(buffer []byte, sz int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rudp.Send(buffer, sz)
+}
+
+// SendV works like RUDP.SendV
+func (s *SyncRUDP) SendV(parts [][]byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rudp.SendV(parts)
+}
+
+// Recv works like RUDP.Recv
+func (s *SyncRUDP) Recv(buffer []byte) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.rudp.Recv(buffer)
+}
+
+// RecvMessage works like RUDP.RecvMessage
+func (s *SyncRUDP) RecvMessage() (*Message, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.rudp.RecvMessage()
+}
+
+// NextSize works like RUDP.NextSize
+func (s *SyncRUDP) NextSize() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.rudp.NextSize()
+}
+
+// Update works like RUDP.Update
+func (s *SyncRUDP) Update(received []byte, sz int, deltaTick int) *RUDPPackage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.rudp.Update(received, sz, deltaTick)
+}
+
+// UpdateAt works like RUDP.UpdateAt
+func (s *SyncRUDP) UpdateAt(received []byte, sz int, now time.Time) *RUDPPackage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.rudp.UpdateAt(received, sz, now)
+}
+
+// Stats works like RUDP.Stats
+func (s *SyncRUDP) Stats() Stats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.rudp.Stats()
+}
@@ -0,0 +1,73 @@
+package rudp_test
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/bennychen/rudp"
+)
+
+func TestSyncConcurrent(t *testing.T) {
+	fmt.Println("=======================TestSyncConcurrent======================")
+
+	const senders = 4
+	const messages = 500
+	A := rudp.NewSync(rudp.Create(1, 1000, 512))
+	B := rudp.NewSync(rudp.Create(1, 1000, 512))
+
+	var wg sync.WaitGroup
+	for s := 0; s < senders; s++ {
+		wg.Add(1)
+		go func(s int) {
+			defer wg.Done()
+			buf := make([]byte, 4)
+			for i := 0; i < messages; i++ {
+				binary.BigEndian.PutUint16(buf, uint16(s))
+				binary.BigEndian.PutUint16(buf[2:], uint16(i))
+				A.Send(buf, len(buf))
+			}
+		}(s)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		// the network goroutine
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			for p := A.Update(nil, 0, 1); p != nil; p = p.Next {
+				B.Update(p.Buffer, p.Size, 0)
+			}
+			for p := B.Update(nil, 0, 1); p != nil; p = p.Next {
+				A.Update(p.Buffer, p.Size, 0)
+			}
+		}
+	}()
+
+	// messages of each sender must arrive in order
+	next := make([]int, senders)
+	tmp := make([]byte, rudp.MaxPackageSize)
+	for received := 0; received < senders*messages; {
+		n := B.Recv(tmp)
+		if n < 0 {
+			t.Fatal("SyncRUDP error, connection should not be corrupt.")
+		}
+		if n == 0 {
+			continue
+		}
+		s := binary.BigEndian.Uint16(tmp)
+		i := int(binary.BigEndian.Uint16(tmp[2:]))
+		if i != next[s] {
+			t.Fatalf("SyncRUDP error, sender %v message %v out of order.", s, i)
+		}
+		next[s]++
+		received++
+	}
+	close(done)
+	wg.Wait()
+}
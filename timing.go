@@ -0,0 +1,51 @@
+package rudp
+
+import "time"
+
+const defaultTick = time.Millisecond
+
+// CreateWithDuration creates a RUDP object configured in real time units,
+// it should be driven by UpdateAt. Durations are converted to ticks of
+// Options.Tick.
+func CreateWithDuration(sendDelay time.Duration, expiredTime time.Duration,
+	mtu int, opts Options) *RUDP {
+	tick := opts.Tick
+	if tick <= 0 {
+		tick = defaultTick
+	}
+	return CreateWithOptions(int(sendDelay/tick), int(expiredTime/tick), mtu, opts)
+}
+
+// UpdateAt is Update with the wall clock time instead of a tick delta,
+// time passed since the previous UpdateAt is turned into ticks and the
+// remainder carries over to the next call
+func (u *RUDP) UpdateAt(received []byte, sz int, now time.Time) *RUDPPackage {
+	if u.lastUpdate.IsZero() {
+		u.lastUpdate = now
+	}
+	deltaTick := 0
+	if elapsed := now.Sub(u.lastUpdate); elapsed >= u.tick {
+		deltaTick = int(elapsed / u.tick)
+		u.lastUpdate = u.lastUpdate.Add(time.Duration(deltaTick) * u.tick)
+	}
+	return u.Update(received, sz, deltaTick)
+}
+
+// NextDeadline returns in how many ticks Update has work to do even if
+// nothing is received, the next send tick or history expiry, 0 means now
+func (u *RUDP) NextDeadline() int {
+	deadline := u.lastSendTick + u.SendDelay
+	if expiry := u.lastExpiredTick + u.ExpiredTime; expiry < deadline {
+		deadline = expiry
+	}
+	if deadline < u.currentTick {
+		return 0
+	}
+	return deadline - u.currentTick
+}
+
+// NextDeadlineAt is NextDeadline in wall clock time for objects driven by
+// UpdateAt, it is only meaningful after the first UpdateAt
+func (u *RUDP) NextDeadlineAt() time.Time {
+	return u.lastUpdate.Add(time.Duration(u.NextDeadline()) * u.tick)
+}
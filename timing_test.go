@@ -0,0 +1,83 @@
+package rudp_test
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/bennychen/rudp"
+)
+
+func TestUpdateAt(t *testing.T) {
+	fmt.Println("=======================TestUpdateAt======================")
+
+	idx = 0
+	U := rudp.CreateWithDuration(
+		10*time.Millisecond, 50*time.Millisecond, 128, rudp.Options{})
+	if U.SendDelay != 10 || U.ExpiredTime != 50 {
+		t.Error("RUDP::CreateWithDuration error, durations should be in milliseconds.")
+	}
+
+	start := time.Unix(1000, 0)
+	U.Send([]byte{1, 2, 3, 4}, 4)
+	if p := U.UpdateAt(nil, 0, start); p != nil {
+		t.Error("RUDP::UpdateAt error, should not send before send delay.")
+	}
+	if p := U.UpdateAt(nil, 0, start.Add(9500*time.Microsecond)); p != nil {
+		t.Error("RUDP::UpdateAt error, should not send before send delay.")
+	}
+	p := U.UpdateAt(nil, 0, start.Add(10*time.Millisecond))
+	if p == nil || p.Size != 7 {
+		t.Error("RUDP::UpdateAt error, should send the message after send delay.")
+	}
+	dump(p)
+
+	// sub tick remainders carry over instead of getting lost
+	for i := 1; i <= 4; i++ {
+		p = U.UpdateAt(nil, 0, start.Add(10*time.Millisecond+time.Duration(i)*2500*time.Microsecond))
+	}
+	if p == nil {
+		t.Error("RUDP::UpdateAt error, should send a heartbeat 10ms later.")
+	}
+	dump(p)
+
+	// history is cleared on the second expiry pass
+	U.UpdateAt(nil, 0, start.Add(60*time.Millisecond))
+	r := []byte{rudp.TypeRequest, 0, 0}
+	p = U.UpdateAt(r, len(r), start.Add(120*time.Millisecond))
+	if p == nil || p.Buffer[0] != rudp.TypeMissing {
+		t.Error("RUDP::UpdateAt error, message should have expired.")
+	}
+	dump(p)
+
+	V := rudp.CreateWithDuration(time.Second, time.Minute, 128,
+		rudp.Options{Tick: 100 * time.Millisecond})
+	if V.SendDelay != 10 || V.ExpiredTime != 600 {
+		t.Error("RUDP::CreateWithDuration error, durations should be in ticks.")
+	}
+}
+
+func TestNextDeadline(t *testing.T) {
+	fmt.Println("=======================TestNextDeadline======================")
+
+	U := rudp.Create(10, 25, 128)
+	if n := U.NextDeadline(); n != 10 {
+		t.Errorf("RUDP::NextDeadline error, first send is due in 10 ticks, not %d.", n)
+	}
+	U.Update(nil, 0, 4)
+	if n := U.NextDeadline(); n != 6 {
+		t.Errorf("RUDP::NextDeadline error, should be 6 not %d.", n)
+	}
+	U.Update(nil, 0, 20) // sends late, next send is 10 ticks from now
+	if n := U.NextDeadline(); n != 1 {
+		t.Errorf("RUDP::NextDeadline error, history expires in 1 tick, not %d.", n)
+	}
+
+	V := rudp.CreateWithDuration(10*time.Millisecond, time.Second, 128, rudp.Options{})
+	start := time.Unix(1000, 0)
+	V.UpdateAt(nil, 0, start)
+	V.UpdateAt(nil, 0, start.Add(2500*time.Microsecond))
+	if at := V.NextDeadlineAt(); !at.Equal(start.Add(10 * time.Millisecond)) {
+		t.Errorf("RUDP::NextDeadlineAt error, got %v.", at.Sub(start))
+	}
+}
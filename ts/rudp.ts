@@ -60,6 +60,11 @@ namespace Rudp {
     //  provider sends normal message to consumer
     public static readonly TypeNormal: number = 4;
 
+    //  largest message sent or accepted, set it to the size agreed by
+    //  RudpCapabilities.negotiate, a larger message from the peer corrupts
+    //  the connection
+    public maxMessageSize: number = Rudp.MaxPackageSize - Rudp.TypeNormal;
+
     public constructor(sendDelay: number, expiredTime: number, mtu: number) {
       this._mtu = mtu;
       if (this._mtu < 128) {
@@ -78,7 +83,7 @@ namespace Rudp {
 
     //  sends a new package out
     public send(buffer: Uint8Array, sz: number): number {
-      if (sz > Rudp.MaxPackageSize - Rudp.TypeNormal) {
+      if (sz > this.maxMessageSize) {
         // package size is too large
         return 1;
       }
@@ -279,7 +284,7 @@ namespace Rudp {
             //  | tag (1~2 bytes) | id (2 bytes) | data |
             //  data is at least 1 byte, so general msg's tag starts from 1
             const dataLength: number = tag - Rudp.TypeNormal;
-            if (sz < dataLength + 2) {
+            if (dataLength > this.maxMessageSize || sz < dataLength + 2) {
               this._corrupt = true;
               return;
             }
@@ -553,6 +558,80 @@ namespace Rudp {
     private static _doubleBytes: Uint8Array = new Uint8Array(2);
   }
 
+  //  RudpCapabilities is the capabilities message peers exchange before
+  //  creating their Rudp objects, so a Go peer does not turn on features
+  //  changing the wire format. This port only speaks the original format,
+  //  it offers no optional features.
+  //  | type (1 byte) | version (1 byte) | max message size (2 bytes) |
+  //  | features (1 byte) | required (1 byte) |
+  //  | fec group size (1 byte) | fec mode (1 byte) | fec parity shards (1 byte) |
+  export class RudpCapabilities {
+    public static readonly ProtocolVersion: number = 1;
+    public static readonly TypeCapabilities: number = 4;
+    public static readonly Size: number = 9;
+
+    public version: number = RudpCapabilities.ProtocolVersion;
+    public maxMessageSize: number = Rudp.MaxPackageSize - Rudp.TypeNormal;
+    public features: number = 0;
+    public required: number = 0;
+    public fecGroupSize: number = 0;
+    public fecMode: number = 0;
+    public fecParityShards: number = 0;
+
+    //  returns the message to send to the peer
+    public marshal(): Uint8Array {
+      const b = new Uint8Array(RudpCapabilities.Size);
+      b[0] = RudpCapabilities.TypeCapabilities;
+      b[1] = this.version;
+      b[2] = (this.maxMessageSize >> 8) & 0xff;
+      b[3] = this.maxMessageSize & 0xff;
+      b[4] = this.features;
+      b[5] = this.required;
+      b[6] = this.fecGroupSize;
+      b[7] = this.fecMode;
+      b[8] = this.fecParityShards;
+      return b;
+    }
+
+    //  reads the message of the peer, null if it is malformed
+    public static parse(buffer: Uint8Array, sz: number): RudpCapabilities {
+      if (
+        sz != RudpCapabilities.Size ||
+        buffer[0] != RudpCapabilities.TypeCapabilities
+      ) {
+        return null;
+      }
+
+      const c = new RudpCapabilities();
+      c.version = buffer[1];
+      c.maxMessageSize = (buffer[2] << 8) | buffer[3];
+      c.features = buffer[4];
+      c.required = buffer[5];
+      c.fecGroupSize = buffer[6];
+      c.fecMode = buffer[7];
+      c.fecParityShards = buffer[8];
+      return c;
+    }
+
+    //  returns the max message size both peers agree on, 0 if the peer
+    //  speaks another version or requires a feature of the Go package
+    public static negotiate(
+      local: RudpCapabilities,
+      remote: RudpCapabilities
+    ): number {
+      if (local.version != remote.version) {
+        return 0;
+      }
+
+      const features = local.features & remote.features;
+      if ((local.required | remote.required) & ~features) {
+        return 0;
+      }
+
+      return Math.max(0, Math.min(local.maxMessageSize, remote.maxMessageSize));
+    }
+  }
+
   export class RudpHelper {
     // big endien get UInt16
     public static getUInt16(buffer: Uint8Array, offset: number): number {
@@ -1104,4 +1104,56 @@ describe('rudp tests', function () {
     );
     DumpAndDestroy(p);
   });
+
+  it('test capabilities', function () {
+    var local = new Rudp.RudpCapabilities();
+    // a Go peer offering checksums and 1200 bytes messages
+    var remote = Rudp.RudpCapabilities.parse(
+      new Uint8Array([4, 1, 0x04, 0xb0, 2, 0, 0, 0, 0]),
+      9
+    );
+    isTrue(remote && remote.maxMessageSize == 1200, 'parse error');
+    assert.equal(
+      Rudp.RudpCapabilities.negotiate(local, remote),
+      Rudp.Rudp.MaxPackageSize - Rudp.Rudp.TypeNormal,
+      'negotiate error, should agree on the smaller max message size.'
+    );
+    isTrue(
+      Rudp.RudpCapabilities.parse(local.marshal(), 9).maxMessageSize ==
+        local.maxMessageSize,
+      'marshal error'
+    );
+
+    remote.required = 2;
+    assert.equal(
+      Rudp.RudpCapabilities.negotiate(local, remote),
+      0,
+      'negotiate error, required feature should be refused.'
+    );
+    remote.required = 0;
+    remote.version = 2;
+    assert.equal(
+      Rudp.RudpCapabilities.negotiate(local, remote),
+      0,
+      'negotiate error, other protocol version should be refused.'
+    );
+    isNull(
+      Rudp.RudpCapabilities.parse(new Uint8Array([4, 1]), 2),
+      'parse error, truncated message should be refused.'
+    );
+
+    // a peer ignoring the agreed size corrupts the connection
+    var U = new Rudp.Rudp(1, 5, 128);
+    var V = new Rudp.Rudp(1, 5, 128);
+    U.maxMessageSize = 4;
+    V.send(new Uint8Array([1, 2, 3, 4, 5]), 5);
+    var p = V.update(null, 0, 1);
+    U.update(p.buffer, p.size, 0);
+    DumpAndDestroy(p);
+    assert.equal(
+      U.recv(new Uint8Array(Rudp.Rudp.MaxPackageSize)),
+      -1,
+      'RUDP::Recv error, message above the agreed size should corrupt.'
+    );
+  });
 });